@@ -0,0 +1,316 @@
+package adminapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"neble_2/config"
+	"neble_2/database"
+	"neble_2/handlers"
+	"neble_2/logging"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// assignment - назначение роли в ответах API
+type assignment struct {
+	ID            int        `json:"id"`
+	GuildID       string     `json:"guild_id"`
+	UserID        string     `json:"user_id"`
+	UserName      string     `json:"user_name"`
+	RoleID        string     `json:"role_id"`
+	RoleName      string     `json:"role_name"`
+	Active        bool       `json:"active"`
+	RenewalStatus string     `json:"renewal_status"`
+	RenewalCount  int        `json:"renewal_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	GraceUntil    *time.Time `json:"grace_until,omitempty"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+	DropReason    string     `json:"drop_reason,omitempty"`
+}
+
+// roleRequest - заявка на роль в истории пользователя
+type roleRequest struct {
+	ID         int        `json:"id"`
+	RoleID     string     `json:"role_id"`
+	RoleName   string     `json:"role_name"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+	DecidedBy  string     `json:"decided_by,omitempty"`
+	DenyReason string     `json:"deny_reason,omitempty"`
+}
+
+func newAssignment(role *database.UserRole) assignment {
+	a := assignment{
+		ID:            role.ID,
+		GuildID:       role.GuildID,
+		UserID:        role.UserID,
+		UserName:      role.UserName,
+		RoleID:        role.RoleID,
+		RoleName:      role.RoleName,
+		Active:        role.IsActive,
+		RenewalStatus: role.RenewalStatus,
+		RenewalCount:  role.RenewalCount,
+		CreatedAt:     role.CreatedAt,
+		ExpiresAt:     role.ExpiresAt,
+		DropReason:    role.DropReason,
+	}
+	if role.GraceUntil.Valid {
+		a.GraceUntil = &role.GraceUntil.Time
+	}
+	if role.DeactivatedAt.Valid {
+		a.DeactivatedAt = &role.DeactivatedAt.Time
+	}
+	return a
+}
+
+func newRoleRequest(req database.RoleRequest) roleRequest {
+	rr := roleRequest{
+		ID:         req.ID,
+		RoleID:     req.RoleID,
+		RoleName:   req.RoleName,
+		Status:     req.Status,
+		CreatedAt:  req.CreatedAt,
+		DecidedBy:  req.DecidedBy,
+		DenyReason: req.DenyReason,
+	}
+	if req.DecidedAt.Valid {
+		rr.DecidedAt = &req.DecidedAt.Time
+	}
+	return rr
+}
+
+// guildConfig находит сервер из пути запроса; при отказе ответ уже отправлен
+func (srv *Server) guildConfig(w http.ResponseWriter, r *http.Request) (*config.Config, bool) {
+	cfg, ok := srv.registry.Get(r.PathValue("guild"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "guild not found")
+		return nil, false
+	}
+	return cfg, true
+}
+
+// loadAssignment загружает назначение из пути запроса и конфигурацию его сервера;
+// при отказе ответ уже отправлен
+func (srv *Server) loadAssignment(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, *database.UserRole, *config.Config, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid assignment id")
+		return ctx, nil, nil, false
+	}
+	ctx = logging.With(ctx, logging.KeyAssignmentID, id)
+
+	role, err := srv.db.GetRoleByID(ctx, id)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return ctx, nil, nil, false
+	}
+	ctx = logging.With(ctx, logging.KeyGuildID, role.GuildID, logging.KeyUserID, role.UserID)
+
+	cfg, ok := srv.registry.Get(role.GuildID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "guild not found")
+		return ctx, nil, nil, false
+	}
+	return ctx, role, cfg, true
+}
+
+// listAssignments - активные назначения сервера, с фильтром ?role_id=
+func (srv *Server) listAssignments(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cfg, ok := srv.guildConfig(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := srv.db.GetActiveRoles(ctx, cfg.GuildID)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return
+	}
+
+	roleID := r.URL.Query().Get("role_id")
+	list := make([]assignment, 0, len(roles))
+	for i := range roles {
+		if roleID == "" || roles[i].RoleID == roleID {
+			list = append(list, newAssignment(&roles[i]))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"assignments": list})
+}
+
+func (srv *Server) getAssignment(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	_, role, _, ok := srv.loadAssignment(ctx, w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAssignment(role))
+}
+
+// userHistory - все назначения и заявки пользователя на сервере, от новых к старым
+func (srv *Server) userHistory(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cfg, ok := srv.guildConfig(w, r)
+	if !ok {
+		return
+	}
+	userID := r.PathValue("user")
+
+	roles, err := srv.db.GetUserRoleHistory(ctx, cfg.GuildID, userID)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return
+	}
+	requests, err := srv.db.GetUserRoleRequests(ctx, cfg.GuildID, userID)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return
+	}
+
+	assignments := make([]assignment, 0, len(roles))
+	for i := range roles {
+		assignments = append(assignments, newAssignment(&roles[i]))
+	}
+	reqs := make([]roleRequest, 0, len(requests))
+	for _, req := range requests {
+		reqs = append(reqs, newRoleRequest(req))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"user_id":     userID,
+		"assignments": assignments,
+		"requests":    reqs,
+	})
+}
+
+type grantRequest struct {
+	UserID  string `json:"user_id"`
+	RoleID  string `json:"role_id"`  // ID роли в Discord
+	RoleKey string `json:"role_key"` // или ключ роли в каталоге
+}
+
+// grant выдаёт роль из каталога сервера. Условия роли и одобрение модератора
+// не проверяются, лимит мест - проверяется.
+func (srv *Server) grant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cfg, ok := srv.guildConfig(w, r)
+	if !ok {
+		return
+	}
+
+	var body grantRequest
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	if body.UserID == "" {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	role, found := cfg.RoleByID(body.RoleID)
+	if body.RoleKey != "" {
+		role, found = cfg.RoleByKey(body.RoleKey)
+	}
+	if !found {
+		writeError(w, http.StatusBadRequest, "role is not in the guild catalog")
+		return
+	}
+	ctx = logging.With(ctx, logging.KeyGuildID, cfg.GuildID, logging.KeyUserID, body.UserID)
+
+	member, err := srv.session.GuildMember(cfg.GuildID, body.UserID, discordgo.WithContext(ctx))
+	if err != nil {
+		var restErr *discordgo.RESTError
+		if errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownMember {
+			writeError(w, http.StatusNotFound, "user is not a member of the guild")
+			return
+		}
+		writeOperationError(ctx, w, fmt.Errorf("get member: %w", err))
+		return
+	}
+
+	granted, err := handlers.GrantRole(ctx, srv.session, srv.db, cfg, body.UserID, member.User.Username, role)
+	audit(ctx, "grant", err, "role_id", role.ID)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAssignment(granted))
+}
+
+type extendRequest struct {
+	Until    *time.Time `json:"until"`    // новый срок
+	Duration string     `json:"duration"` // или сколько добавить от текущего момента ("7d", "12h")
+}
+
+// extend продлевает активное назначение; без параметров - на срок роли сервера
+func (srv *Server) extend(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var body extendRequest
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+
+	ctx, role, cfg, ok := srv.loadAssignment(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	until := time.Now().Add(cfg.RoleDuration)
+	switch {
+	case body.Until != nil:
+		until = *body.Until
+	case body.Duration != "":
+		d, err := config.ParseDuration(body.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration")
+			return
+		}
+		until = time.Now().Add(d)
+	}
+	if !until.After(time.Now()) {
+		writeError(w, http.StatusBadRequest, "new expiry must be in the future")
+		return
+	}
+
+	err := handlers.ExtendRole(ctx, srv.session, srv.db, cfg, role, until)
+	audit(ctx, "extend", err, "role_id", role.RoleID, "until", until)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return
+	}
+
+	updated, err := srv.db.GetRoleByID(ctx, role.ID)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAssignment(updated))
+}
+
+type revokeRequest struct {
+	Reason string `json:"reason"` // только для аудита: в статистику причин отказа не попадает
+}
+
+// revoke снимает активное назначение
+func (srv *Server) revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var body revokeRequest
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+
+	ctx, role, cfg, ok := srv.loadAssignment(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	err := handlers.RevokeRole(ctx, srv.session, srv.db, cfg, role, "")
+	audit(ctx, "revoke", err, "role_id", role.RoleID, "reason", body.Reason)
+	if err != nil {
+		writeOperationError(ctx, w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
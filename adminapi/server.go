@@ -0,0 +1,156 @@
+package adminapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"neble_2/database"
+	"neble_2/guilds"
+	"neble_2/handlers"
+	"neble_2/lifecycle"
+	"neble_2/logging"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// requestTimeout ограничивает один запрос к API вместе с запросами к БД и Discord
+	requestTimeout = 15 * time.Second
+	// maxBodySize - предел тела запроса
+	maxBodySize = 64 << 10
+	// keyActor - кто на сайте выполнил действие (заголовок X-Actor); попадает в аудит
+	keyActor = "actor"
+)
+
+// Server - админский REST API для назначений ролей. Включается токеном
+// ADMIN_API_TOKEN: без него все запросы получают 404. Изменения идут через те же
+// операции, что и кнопки Discord, поэтому статистика, очередь и метрики
+// обновляются так же, а каждое изменение пишется в лог аудита.
+type Server struct {
+	session  *discordgo.Session
+	db       *database.DB
+	registry *guilds.Registry
+	life     *lifecycle.Manager
+}
+
+func New(s *discordgo.Session, db *database.DB, registry *guilds.Registry, life *lifecycle.Manager) *Server {
+	return &Server{session: s, db: db, registry: registry, life: life}
+}
+
+// apiHandler - обработчик запроса к API; ответ пишется через writeJSON или writeError
+type apiHandler func(ctx context.Context, w http.ResponseWriter, r *http.Request)
+
+// Register добавляет маршруты API в mux
+func (srv *Server) Register(mux *http.ServeMux) {
+	mux.Handle("GET /api/v1/guilds/{guild}/assignments", srv.wrap(srv.listAssignments, false))
+	mux.Handle("POST /api/v1/guilds/{guild}/assignments", srv.wrap(srv.grant, true))
+	mux.Handle("GET /api/v1/guilds/{guild}/users/{user}/history", srv.wrap(srv.userHistory, false))
+	mux.Handle("GET /api/v1/assignments/{id}", srv.wrap(srv.getAssignment, false))
+	mux.Handle("POST /api/v1/assignments/{id}/extend", srv.wrap(srv.extend, true))
+	mux.Handle("DELETE /api/v1/assignments/{id}", srv.wrap(srv.revoke, true))
+}
+
+// wrap проверяет токен и готовит контекст запроса. Изменяющие запросы (mutating)
+// учитываются при плавной остановке и во время неё отклоняются.
+func (srv *Server) wrap(h apiHandler, mutating bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := srv.registry.Base().AdminAPIToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !validToken(r, token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="neble"`)
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+
+		// Клиент может оборвать соединение, а начатое изменение должно доделаться
+		ctx, cancel := context.WithTimeout(srv.life.Context(), requestTimeout)
+		defer cancel()
+
+		actor := r.Header.Get("X-Actor")
+		if actor == "" {
+			actor = "api"
+		}
+		ctx = logging.WithCorrelationID(logging.With(ctx, keyActor, actor))
+
+		if mutating {
+			done, ok := srv.life.Begin()
+			if !ok {
+				writeError(w, http.StatusServiceUnavailable, "shutting down")
+				return
+			}
+			defer done()
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		h(ctx, w, r)
+	})
+}
+
+// validToken сравнивает токен из "Authorization: Bearer ..." за постоянное время
+func validToken(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// audit записывает изменение, сделанное через API: кто, что и с каким результатом
+func audit(ctx context.Context, action string, err error, args ...any) {
+	args = append([]any{"action", action}, args...)
+	if err != nil {
+		slog.WarnContext(ctx, "Admin API audit", append(args, "result", "failed", logging.Err(err))...)
+		return
+	}
+	slog.InfoContext(ctx, "Admin API audit", append(args, "result", "ok")...)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Error writing API response", logging.Err(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
+
+// writeOperationError переводит ошибку операции в ответ API. Внутренние ошибки
+// логируются, клиенту уходит только общий текст.
+func writeOperationError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		writeError(w, http.StatusNotFound, "assignment not found")
+	case errors.Is(err, database.ErrActiveRoleExists):
+		writeError(w, http.StatusConflict, "user already has an active role")
+	case errors.Is(err, handlers.ErrRoleFull):
+		writeError(w, http.StatusConflict, "role is full")
+	case errors.Is(err, handlers.ErrAssignmentInactive):
+		writeError(w, http.StatusConflict, "assignment is not active")
+	default:
+		slog.ErrorContext(ctx, "Admin API request failed", logging.Err(err))
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// decodeBody читает JSON из тела запроса. Пустое тело допустимо - остаются значения по умолчанию.
+func decodeBody(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
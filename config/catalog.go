@@ -0,0 +1,151 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// MaxRoles - ролей в каталоге: на панели четыре ряда по пять кнопок, пятый ряд занят служебными кнопками
+const MaxRoles = 20
+
+// RoleDefinition - роль из каталога: кнопка на панели и правила её получения
+type RoleDefinition struct {
+	Key         string      `json:"key"`   // часть custom_id кнопки, не меняется при переименовании
+	ID          string      `json:"id"`    // ID роли в Discord
+	Name        string      `json:"name"`  // название в сообщениях и статистике
+	Label       string      `json:"label"` // текст кнопки, по умолчанию Name
+	Style       string      `json:"style"` // primary, secondary, success, danger
+	Eligibility Eligibility `json:"eligibility"`
+
+	// MaxHolders ограничивает число одновременных обладателей роли (0 - без ограничения).
+	// Когда мест нет, пользователи встают в очередь.
+	MaxHolders int `json:"max_holders"`
+	// AutoAssignWaitlist - выдавать роль первому в очереди автоматически,
+	// иначе ему придерживается место и отправляется уведомление
+	AutoAssignWaitlist bool `json:"auto_assign_waitlist"`
+	// RequiresApproval - роль выдаётся только после одобрения модератором в STAFF_CHANNEL_ID
+	RequiresApproval bool `json:"requires_approval"`
+	// AutoRenewWindow - продлевать роль без вопроса, если пользователь писал сообщения
+	// или заходил в голосовые каналы за это время (0 - всегда спрашивать)
+	AutoRenewWindow Duration `json:"auto_renew_window"`
+	// AutoRenewMinVoice - для автопродления нужно ещё и провести столько времени
+	// в голосовых каналах за AutoRenewWindow
+	AutoRenewMinVoice Duration `json:"auto_renew_min_voice"`
+}
+
+// ButtonLabel возвращает текст кнопки роли на панели
+func (r RoleDefinition) ButtonLabel() string {
+	if r.Label != "" {
+		return r.Label
+	}
+	return r.Name
+}
+
+// Eligibility - условия, при которых роль можно выбрать или продлить.
+// Нулевые значения означают отсутствие ограничения.
+type Eligibility struct {
+	RequiredRoles  []string `json:"required_roles"`  // нужна хотя бы одна из ролей
+	ForbiddenRoles []string `json:"forbidden_roles"` // ни одной из ролей быть не должно
+	MinAccountAge  Duration `json:"min_account_age"` // возраст аккаунта Discord
+	MinGuildAge    Duration `json:"min_guild_age"`   // время с момента входа на сервер
+	MaxRenewals    int      `json:"max_renewals"`    // сколько раз роль можно продлить подряд
+}
+
+// Duration читается из JSON строкой вида "72h", "30m" или "7d"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("duration must be a string like \"72h\" or \"7d\": %w", err)
+	}
+	parsed, err := ParseDuration(raw)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// defaultRoleCatalog - роли, которые использовались до появления файла каталога
+func defaultRoleCatalog() []RoleDefinition {
+	return []RoleDefinition{
+		{Key: "1", ID: "1439750973861007442", Name: "Сенди-Шорс", Style: "primary"},
+		{Key: "2", ID: "1439751278925316116", Name: "ХПалето-Бэй", Label: "Палето-Бэй", Style: "success"},
+	}
+}
+
+// loadRoleCatalog читает каталог ролей из JSON-файла; без файла используется каталог по умолчанию
+func loadRoleCatalog(path string) ([]RoleDefinition, error) {
+	if path == "" {
+		return defaultRoleCatalog(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read role catalog: %w", err)
+	}
+
+	return ParseRoleCatalog(data, path)
+}
+
+// ParseRoleCatalog разбирает и проверяет JSON-каталог ролей; source - откуда он взят, для ошибок
+func ParseRoleCatalog(data []byte, source string) ([]RoleDefinition, error) {
+	var roles []RoleDefinition
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, fmt.Errorf("parse role catalog %s: %w", source, err)
+	}
+
+	if len(roles) > MaxRoles {
+		return nil, fmt.Errorf("role catalog %s: at most %d roles fit on the panel, got %d", source, MaxRoles, len(roles))
+	}
+
+	seen := make(map[string]bool)
+	for _, role := range roles {
+		if role.Key == "" || role.ID == "" || role.Name == "" {
+			return nil, fmt.Errorf("role catalog %s: key, id and name are required", source)
+		}
+		// Ключ попадает в custom_id кнопки, где ":" разделяет части
+		if strings.Contains(role.Key, ":") {
+			return nil, fmt.Errorf("role catalog %s: key %q must not contain \":\"", source, role.Key)
+		}
+		if !snowflake.MatchString(role.ID) {
+			return nil, fmt.Errorf("role catalog %s: role %q has invalid id %q", source, role.Key, role.ID)
+		}
+		if seen[role.Key] {
+			return nil, fmt.Errorf("role catalog %s: duplicate key %q", source, role.Key)
+		}
+		seen[role.Key] = true
+	}
+
+	return roles, nil
+}
+
+// RoleByKey ищет роль каталога по ключу кнопки
+func (c *Config) RoleByKey(key string) (RoleDefinition, bool) {
+	for _, role := range c.Roles {
+		if role.Key == key {
+			return role, true
+		}
+	}
+	return RoleDefinition{}, false
+}
+
+// RoleByID ищет роль каталога по ID роли в Discord
+func (c *Config) RoleByID(id string) (RoleDefinition, bool) {
+	for _, role := range c.Roles {
+		if role.ID == id {
+			return role, true
+		}
+	}
+	return RoleDefinition{}, false
+}
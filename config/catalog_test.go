@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseRoleCatalog(t *testing.T) {
+	tooMany := make([]string, MaxRoles+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf(`{"key":"r%d","id":"1439750973861007%03d","name":"Роль %d"}`, i, i, i)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "valid", data: `[{"key":"1","id":"1439750973861007442","name":"Сенди-Шорс"}]`},
+		{name: "empty", data: `[]`},
+		{name: "max roles", data: "[" + strings.Join(tooMany[:MaxRoles], ",") + "]"},
+		{name: "too many roles", data: "[" + strings.Join(tooMany, ",") + "]", wantErr: true},
+		{name: "missing name", data: `[{"key":"1","id":"1439750973861007442"}]`, wantErr: true},
+		{name: "separator in key", data: `[{"key":"a:b","id":"1439750973861007442","name":"Роль"}]`, wantErr: true},
+		{name: "invalid id", data: `[{"key":"1","id":"role","name":"Роль"}]`, wantErr: true},
+		{name: "short id", data: `[{"key":"1","id":"12345","name":"Роль"}]`, wantErr: true},
+		{
+			name:    "duplicate key",
+			data:    `[{"key":"1","id":"1439750973861007442","name":"А"},{"key":"1","id":"1439751278925316116","name":"Б"}]`,
+			wantErr: true,
+		},
+		{name: "not json", data: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRoleCatalog([]byte(tt.data), "test")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRoleCatalog error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,46 +1,192 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
 	"os"
-	"strconv"
+	"slices"
 	"time"
 )
 
+// maxDropReasonOptions - вариантов причины в меню выбора Discord (до 25 пунктов вместе с "Другое")
+const maxDropReasonOptions = 24
+
 type Config struct {
 	Token                 string
 	GuildID               string
 	RoleChannelID         string
 	NotificationChannelID string
 	StatsChannelID        string
+	StaffChannelID        string
 	RoleDuration          time.Duration
 	RenewalDuration       time.Duration
 	RoleMessageID         string
+	RenewalDMDefault      bool
+	DBQueryTimeout        time.Duration
+	CustomIDSecret        string
+	Roles                 []RoleDefinition
+	WaitlistReservation   time.Duration // сколько места ждут уведомлённого из очереди
+
+	// Льготный период после неотвеченного вопроса о продлении (0 - роль снимается сразу).
+	// На это время роль заменяется ролью-меткой InactiveRoleID, если она задана.
+	GracePeriod    time.Duration
+	InactiveRoleID string
+
+	// StartupCheck - проверить при запуске через API Discord, что сервер, каналы
+	// и роли существуют и бот может выдавать роли
+	StartupCheck bool
+	// ConfigWatchInterval - как часто проверять, не изменились ли .env и каталог ролей
+	// (0 - только по SIGHUP)
+	ConfigWatchInterval time.Duration
+	// LogLevel - минимальный уровень логов; меняется и при перезагрузке конфигурации
+	LogLevel slog.Level
+	// HTTPAddr - адрес служебного HTTP-сервера, например ":8080": метрики Prometheus (/metrics)
+	// и проверки для оркестратора (/healthz, /readyz); пустой - сервер не запускается
+	HTTPAddr string
+	// AdminAPIToken - токен админского REST API на служебном HTTP-сервере
+	// (Authorization: Bearer ...); пустой - API выключен
+	AdminAPIToken string
+
+	// Вебхуки о событиях ролей: получатели из файла WEBHOOKS_FILE, доставка через
+	// очередь в БД с повторами, пока не кончатся попытки
+	Webhooks           []Webhook
+	WebhookTimeout     time.Duration // ожидание ответа получателя на одну попытку
+	WebhookMaxAttempts int
+
+	// ShutdownTimeout - сколько при остановке ждать начатые обработчики, задачи
+	// и обновления статистики, прежде чем прервать их
+	ShutdownTimeout time.Duration
+
+	// Расписания задач планировщика: интервал ("1m", "@every 1h") или cron-выражение
+	ExpiryScanSchedule        string
+	TimeoutResolutionSchedule string
+	ReconciliationSchedule    string
+	StatsRefreshSchedule      string
+	CleanupSchedule           string
+	WebhookDeliverySchedule   string
+	SchedulerJitter           time.Duration
+
+	// Защита от спама кнопками: кулдауны на пользователя и общий лимит REST-запросов к Discord
+	CooldownSelect   time.Duration
+	CooldownRemove   time.Duration
+	CooldownRenew    time.Duration
+	DiscordRateLimit float64 // запросов в секунду
+	DiscordRateBurst int
+
+	// Опрос причины, когда пользователь сам отказывается от роли
+	DropReasonPrompt  bool
+	DropReasonOptions []string // варианты для выбора; без них причина вводится текстом
 }
 
-func Load() *Config {
-	return &Config{
-		Token:                 getEnv("BOT_TOKEN", ""),
-		GuildID:               getEnv("GUILD_ID", ""),
-		RoleChannelID:         getEnv("ROLE_CHANNEL_ID", ""),
-		NotificationChannelID: getEnv("NOTIFICATION_CHANNEL_ID", ""),
-		StatsChannelID:        getEnv("STATS_CHANNEL_ID", ""),
-		RoleDuration:          getDurationEnv("ROLE_DURATION_HOURS", 65) * time.Minute,
-		RenewalDuration:       getDurationEnv("RENEWAL_DURATION_HOURS", 10) * time.Minute,
+func Load() (*Config, error) {
+	env := &envReader{}
+	cfg := &Config{
+		Token:                 env.str("BOT_TOKEN", ""),
+		GuildID:               env.str("GUILD_ID", ""),
+		RoleChannelID:         env.str("ROLE_CHANNEL_ID", ""),
+		NotificationChannelID: env.str("NOTIFICATION_CHANNEL_ID", ""),
+		StatsChannelID:        env.str("STATS_CHANNEL_ID", ""),
+		StaffChannelID:        env.str("STAFF_CHANNEL_ID", ""),
+		RoleDuration:          env.hours("ROLE_DURATION_HOURS", 65*time.Hour),
+		RenewalDuration:       env.hours("RENEWAL_DURATION_HOURS", 10*time.Hour),
+		RenewalDMDefault:      env.bool("RENEWAL_DM_DEFAULT", false),
+		DBQueryTimeout:        env.duration("DB_QUERY_TIMEOUT", 5*time.Second),
+		WaitlistReservation:   env.duration("WAITLIST_RESERVATION", time.Hour),
+		GracePeriod:           env.duration("GRACE_PERIOD", 0),
+		InactiveRoleID:        env.str("INACTIVE_ROLE_ID", ""),
+		StartupCheck:          env.bool("STARTUP_CHECK", true),
+		ConfigWatchInterval:   env.duration("CONFIG_WATCH_INTERVAL", 10*time.Second),
+		LogLevel:              env.level("LOG_LEVEL", slog.LevelInfo),
+		HTTPAddr:              HTTPAddrFromEnv(),
+		AdminAPIToken:         env.str("ADMIN_API_TOKEN", ""),
+		ShutdownTimeout:       env.duration("SHUTDOWN_TIMEOUT", 20*time.Second),
+		WebhookTimeout:        env.duration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:    env.int("WEBHOOK_MAX_ATTEMPTS", 12),
+
+		ExpiryScanSchedule:        env.str("SCHEDULE_EXPIRY_SCAN", "1m"),
+		TimeoutResolutionSchedule: env.str("SCHEDULE_TIMEOUT_RESOLUTION", "1m"),
+		ReconciliationSchedule:    env.str("SCHEDULE_RECONCILIATION", "@hourly"),
+		StatsRefreshSchedule:      env.str("SCHEDULE_STATS_REFRESH", "15m"),
+		CleanupSchedule:           env.str("SCHEDULE_CLEANUP", "0 4 * * *"),
+		WebhookDeliverySchedule:   env.str("SCHEDULE_WEBHOOK_DELIVERY", "15s"),
+		SchedulerJitter:           env.duration("SCHEDULER_JITTER", 5*time.Second),
+
+		CooldownSelect:   env.duration("COOLDOWN_SELECT", 10*time.Second),
+		CooldownRemove:   env.duration("COOLDOWN_REMOVE", 10*time.Second),
+		CooldownRenew:    env.duration("COOLDOWN_RENEW", 3*time.Second),
+		DiscordRateLimit: env.float("DISCORD_RATE_LIMIT", 25),
+		DiscordRateBurst: env.int("DISCORD_RATE_BURST", 40),
+
+		DropReasonPrompt:  env.bool("DROP_REASON_PROMPT", false),
+		DropReasonOptions: env.list("DROP_REASON_OPTIONS", ";"),
+	}
+
+	// Без явного секрета подписываем custom_id производным от токена ключом:
+	// он стабилен между перезапусками, и кнопки на старых сообщениях продолжают работать
+	cfg.CustomIDSecret = env.str("CUSTOM_ID_SECRET", "")
+	if cfg.CustomIDSecret == "" {
+		sum := sha256.Sum256([]byte("custom-id:" + cfg.Token))
+		cfg.CustomIDSecret = hex.EncodeToString(sum[:])
+	}
+
+	roles, err := loadRoleCatalog(env.str("ROLE_CATALOG_FILE", ""))
+	if err != nil {
+		env.problem("%v", err)
 	}
+	cfg.Roles = roles
+
+	webhooks, err := loadWebhooks(env.str("WEBHOOKS_FILE", ""))
+	if err != nil {
+		env.problem("%v", err)
+	}
+	cfg.Webhooks = webhooks
+
+	cfg.validate(env)
+	if err := env.err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// ForGuild возвращает копию конфигурации для сервера guildID. Каналы и каталог ролей
+// из окружения относятся только к серверу GUILD_ID: остальные серверы начинают
+// без них и настраиваются через свои настройки в БД.
+func (c *Config) ForGuild(guildID string) *Config {
+	cfg := *c
+	cfg.Roles = slices.Clone(c.Roles)
+	cfg.DropReasonOptions = slices.Clone(c.DropReasonOptions)
+	cfg.Webhooks = slices.Clone(c.Webhooks)
+
+	if guildID != c.GuildID {
+		cfg.RoleChannelID = ""
+		cfg.NotificationChannelID = ""
+		cfg.StatsChannelID = ""
+		cfg.StaffChannelID = ""
+		cfg.RoleMessageID = ""
+		cfg.InactiveRoleID = ""
+		cfg.Roles = nil
 	}
-	return defaultValue
+	cfg.GuildID = guildID
+	return &cfg
 }
 
-func getDurationEnv(key string, defaultValue int) time.Duration {
+// HTTPAddrFromEnv читает HTTP_ADDR. Старое имя METRICS_ADDR (служебный сервер раньше
+// отдавал только метрики) ещё поддерживается, если HTTP_ADDR не задан
+func HTTPAddrFromEnv() string {
+	if addr := os.Getenv("HTTP_ADDR"); addr != "" {
+		return addr
+	}
+	addr := os.Getenv("METRICS_ADDR")
+	if addr != "" {
+		slog.Warn("METRICS_ADDR is deprecated, use HTTP_ADDR instead")
+	}
+	return addr
+}
+
+func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
-		if hours, err := strconv.Atoi(value); err == nil {
-			return time.Duration(hours)
-		}
+		return value
 	}
-	return time.Duration(defaultValue)
+	return defaultValue
 }
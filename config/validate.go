@@ -0,0 +1,290 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"neble_2/logging"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationPart - одно число с единицей в строке длительности: "7d", "12h", "1.5h"
+var durationPart = regexp.MustCompile(`^(\d+(?:\.\d+)?)([a-zµμ]+)`)
+
+// snowflake - ID объекта Discord
+var snowflake = regexp.MustCompile(`^\d{17,20}$`)
+
+// ParseDuration разбирает длительность как time.ParseDuration и дополнительно
+// понимает дни и недели: "7d", "1d12h", "2w"
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	if value == "0" {
+		return 0, nil
+	}
+
+	var total time.Duration
+	for rest := value; rest != ""; {
+		match := durationPart.FindStringSubmatch(rest)
+		if match == nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		rest = rest[len(match[0]):]
+
+		var part time.Duration
+		switch match[2] {
+		case "d", "w":
+			unit := 24 * time.Hour
+			if match[2] == "w" {
+				unit *= 7
+			}
+			n, err := strconv.ParseFloat(match[1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			// Без проверки переполнение дало бы отрицательную длительность
+			if n*float64(unit) >= math.MaxInt64 {
+				return 0, fmt.Errorf("duration %q is too long", value)
+			}
+			part = time.Duration(n * float64(unit))
+		default:
+			var err error
+			part, err = time.ParseDuration(match[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+		}
+
+		if total > math.MaxInt64-part {
+			return 0, fmt.Errorf("duration %q is too long", value)
+		}
+		total += part
+	}
+	return total, nil
+}
+
+// envReader читает переменные окружения и копит ошибки, чтобы Load сообщил
+// обо всех опечатках сразу, а не молча подставил значения по умолчанию
+type envReader struct {
+	problems []string
+}
+
+func (e *envReader) problem(format string, args ...any) {
+	e.problems = append(e.problems, fmt.Sprintf(format, args...))
+}
+
+func (e *envReader) str(key, defaultValue string) string {
+	return getEnv(key, defaultValue)
+}
+
+// duration читает длительность с единицами: "65h", "7d", "30m"
+func (e *envReader) duration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := ParseDuration(value)
+	if err != nil || d < 0 {
+		e.problem("%s: expected a duration like \"65h\" or \"7d\", got %q", key, value)
+		return defaultValue
+	}
+	return d
+}
+
+// hours читает переменные *_HOURS: число без единицы - это часы,
+// с единицей значение разбирается как обычная длительность
+func (e *envReader) hours(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		switch {
+		case math.IsNaN(n) || math.IsInf(n, 0):
+			e.problem("%s: must be a finite number, got %q", key, value)
+			return defaultValue
+		case n < 0:
+			e.problem("%s: must not be negative, got %q", key, value)
+			return defaultValue
+		case n > math.MaxInt64/float64(time.Hour):
+			e.problem("%s: %q hours is too long", key, value)
+			return defaultValue
+		}
+		return time.Duration(n * float64(time.Hour))
+	}
+	return e.duration(key, defaultValue)
+}
+
+// level читает уровень логов: debug, info, warn или error
+func (e *envReader) level(key string, defaultValue slog.Level) slog.Level {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	l, err := logging.ParseLevel(value)
+	if err != nil {
+		e.problem("%s: %v", key, err)
+		return defaultValue
+	}
+	return l
+}
+
+func (e *envReader) bool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.problem("%s: expected true or false, got %q", key, value)
+		return defaultValue
+	}
+	return b
+}
+
+func (e *envReader) int(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		e.problem("%s: expected an integer, got %q", key, value)
+		return defaultValue
+	}
+	return n
+}
+
+func (e *envReader) float(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		e.problem("%s: expected a number, got %q", key, value)
+		return defaultValue
+	}
+	return f
+}
+
+// list разбивает значение переменной по разделителю, пропуская пустые элементы
+func (e *envReader) list(key, separator string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// minAdminTokenLength - минимальная длина токена админского API
+const minAdminTokenLength = 16
+
+// validate проверяет обязательные значения и согласованность конфигурации
+func (c *Config) validate(e *envReader) {
+	if c.Token == "" {
+		e.problem("BOT_TOKEN is required")
+	}
+
+	ids := []struct {
+		key   string
+		value string
+	}{
+		{"GUILD_ID", c.GuildID},
+		{"ROLE_CHANNEL_ID", c.RoleChannelID},
+		{"NOTIFICATION_CHANNEL_ID", c.NotificationChannelID},
+		{"STATS_CHANNEL_ID", c.StatsChannelID},
+		{"STAFF_CHANNEL_ID", c.StaffChannelID},
+		{"INACTIVE_ROLE_ID", c.InactiveRoleID},
+	}
+	for _, id := range ids {
+		if id.value != "" && !snowflake.MatchString(id.value) {
+			e.problem("%s: %q is not a Discord ID", id.key, id.value)
+		}
+	}
+
+	// Каналы из окружения относятся к серверу GUILD_ID: без него их некуда применить.
+	// Сами каналы не обязательны - их можно задать через /setup.
+	if c.GuildID == "" {
+		for _, id := range ids[1:] {
+			if id.value != "" {
+				e.problem("%s is set but GUILD_ID is empty", id.key)
+			}
+		}
+	}
+
+	positive := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"ROLE_DURATION_HOURS", c.RoleDuration},
+		{"RENEWAL_DURATION_HOURS", c.RenewalDuration},
+		{"DB_QUERY_TIMEOUT", c.DBQueryTimeout},
+		{"WAITLIST_RESERVATION", c.WaitlistReservation},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"WEBHOOK_TIMEOUT", c.WebhookTimeout},
+	}
+	for _, d := range positive {
+		if d.value <= 0 {
+			e.problem("%s must be greater than zero", d.key)
+		}
+	}
+	if c.HTTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTPAddr); err != nil {
+			e.problem("HTTP_ADDR: %v", err)
+		}
+	}
+	if c.AdminAPIToken != "" {
+		if c.HTTPAddr == "" {
+			e.problem("ADMIN_API_TOKEN is set but HTTP_ADDR is empty")
+		}
+		if len(c.AdminAPIToken) < minAdminTokenLength {
+			e.problem("ADMIN_API_TOKEN must be at least %d characters long", minAdminTokenLength)
+		}
+	}
+	if c.WebhookMaxAttempts < 1 {
+		e.problem("WEBHOOK_MAX_ATTEMPTS must be at least 1")
+	}
+	if c.DiscordRateLimit < 0 {
+		e.problem("DISCORD_RATE_LIMIT must not be negative")
+	}
+	if c.DiscordRateLimit > 0 && c.DiscordRateBurst < 1 {
+		e.problem("DISCORD_RATE_BURST must be at least 1")
+	}
+
+	// Одно место в меню выбора занимает вариант "Другое"
+	if len(c.DropReasonOptions) > maxDropReasonOptions {
+		e.problem("DROP_REASON_OPTIONS: at most %d options are supported, got %d",
+			maxDropReasonOptions, len(c.DropReasonOptions))
+	}
+	for _, option := range c.DropReasonOptions {
+		if len([]rune(option)) > 100 {
+			e.problem("DROP_REASON_OPTIONS: option %q is longer than 100 characters", option)
+		}
+	}
+
+	for _, hook := range c.Webhooks {
+		for _, guildID := range hook.Guilds {
+			if !snowflake.MatchString(guildID) {
+				e.problem("webhooks: webhook %q has invalid guild id %q", hook.Name, guildID)
+			}
+		}
+	}
+}
+
+// err собирает накопленные ошибки в один отчёт
+func (e *envReader) err() error {
+	if len(e.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(e.problems, "\n  - "))
+}
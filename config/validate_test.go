@@ -0,0 +1,92 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "1d12h", want: 36 * time.Hour},
+		{value: "1.5h", want: 90 * time.Minute},
+		{value: "1.5d", want: 36 * time.Hour},
+		{value: "2w", want: 14 * 24 * time.Hour},
+		{value: "1w2d3h4m5s", want: 9*24*time.Hour + 3*time.Hour + 4*time.Minute + 5*time.Second},
+		{value: "90m", want: 90 * time.Minute},
+		{value: " 65h ", want: 65 * time.Hour},
+		{value: "0", want: 0},
+		{value: "0d", want: 0},
+		{value: "-1h", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "   ", wantErr: true},
+		{value: "10x", wantErr: true},
+		{value: "12", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "7d garbage", wantErr: true},
+		// Переполнение time.Duration (около 292 лет)
+		{value: "100000000d", wantErr: true},
+		{value: "20000w", wantErr: true},
+		{value: "3000000h", wantErr: true},
+		{value: "106751d106751d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDuration(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestEnvReaderHours(t *testing.T) {
+	const key = "TEST_DURATION_HOURS"
+	const defaultValue = 168 * time.Hour
+
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: defaultValue},
+		{value: "24", want: 24 * time.Hour},
+		{value: "0.5", want: 30 * time.Minute},
+		{value: "0", want: 0},
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "2000000", want: 2000000 * time.Hour},
+		{value: "-1", wantErr: true},
+		{value: "1e12", wantErr: true},
+		{value: "3000000", wantErr: true},
+		{value: "NaN", wantErr: true},
+		{value: "Inf", wantErr: true},
+		{value: "-Inf", wantErr: true},
+		{value: "abc", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv(key, tt.value)
+		e := &envReader{}
+		got := e.hours(key, defaultValue)
+		if tt.wantErr {
+			if len(e.problems) == 0 {
+				t.Errorf("hours(%q) = %v, want a problem", tt.value, got)
+			} else if got != defaultValue {
+				t.Errorf("hours(%q) = %v, want the default %v", tt.value, got, defaultValue)
+			}
+			continue
+		}
+		if len(e.problems) > 0 || got != tt.want {
+			t.Errorf("hours(%q) = %v, problems %v; want %v", tt.value, got, e.problems, tt.want)
+		}
+	}
+}
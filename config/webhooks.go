@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// webhookEvents - события, на которые можно подписать вебхук (константы пакета webhooks)
+var webhookEvents = []string{"granted", "renewed", "expired", "removed"}
+
+// minWebhookSecretLength - минимальная длина секрета подписи вебхука
+const minWebhookSecretLength = 16
+
+// Webhook - внешний получатель событий о ролях. Каждое событие отправляется
+// POST-запросом с JSON и подписью HMAC-SHA256 от Secret.
+type Webhook struct {
+	Name   string   `json:"name"`   // ключ очереди доставки, не меняется при смене адреса
+	URL    string   `json:"url"`    // http:// или https://
+	Secret string   `json:"secret"` // ключ подписи X-Neble-Signature
+	Events []string `json:"events"` // granted, renewed, expired, removed; пустой - все
+	Guilds []string `json:"guilds"` // ID серверов; пустой - все серверы
+}
+
+// Wants сообщает, подписан ли вебхук на событие event сервера guildID
+func (w Webhook) Wants(event, guildID string) bool {
+	if len(w.Events) > 0 && !slices.Contains(w.Events, event) {
+		return false
+	}
+	return len(w.Guilds) == 0 || slices.Contains(w.Guilds, guildID)
+}
+
+// loadWebhooks читает вебхуки из JSON-файла; без файла вебхуков нет
+func loadWebhooks(path string) ([]Webhook, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read webhooks file: %w", err)
+	}
+
+	return ParseWebhooks(data, path)
+}
+
+// ParseWebhooks разбирает и проверяет JSON-список вебхуков; source - откуда он взят, для ошибок
+func ParseWebhooks(data []byte, source string) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		return nil, fmt.Errorf("parse webhooks %s: %w", source, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, hook := range webhooks {
+		if hook.Name == "" || hook.URL == "" {
+			return nil, fmt.Errorf("webhooks %s: name and url are required", source)
+		}
+		if seen[hook.Name] {
+			return nil, fmt.Errorf("webhooks %s: duplicate name %q", source, hook.Name)
+		}
+		seen[hook.Name] = true
+
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhooks %s: webhook %q has invalid url", source, hook.Name)
+		}
+		if len(hook.Secret) < minWebhookSecretLength {
+			return nil, fmt.Errorf("webhooks %s: webhook %q: secret must be at least %d characters long",
+				source, hook.Name, minWebhookSecretLength)
+		}
+		for _, event := range hook.Events {
+			if !slices.Contains(webhookEvents, event) {
+				return nil, fmt.Errorf("webhooks %s: webhook %q: unknown event %q", source, hook.Name, event)
+			}
+		}
+	}
+
+	return webhooks, nil
+}
+
+// WebhookByName ищет вебхук по имени
+func (c *Config) WebhookByName(name string) (Webhook, bool) {
+	for _, hook := range c.Webhooks {
+		if hook.Name == name {
+			return hook, true
+		}
+	}
+	return Webhook{}, false
+}
@@ -0,0 +1,130 @@
+// Package customid кодирует custom_id кнопок и модальных окон.
+//
+// Формат: "v1:действие:аргумент:nonce:подпись". Подпись - укороченный
+// HMAC-SHA256 от остальных полей, поэтому кнопки, собранные не ботом или
+// другой версией формата, отклоняются до того, как попадут в обработчик.
+package customid
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	version   = "v1"
+	separator = ":"
+	sigLength = 12
+	// maxLength - ограничение Discord на длину custom_id
+	maxLength = 100
+)
+
+var (
+	ErrMalformed          = errors.New("malformed custom id")
+	ErrUnsupportedVersion = errors.New("unsupported custom id version")
+	ErrBadSignature       = errors.New("invalid custom id signature")
+	ErrTooLong            = errors.New("custom id is too long")
+	ErrSeparator          = errors.New("custom id field contains separator")
+)
+
+// ID - разобранный custom_id
+type ID struct {
+	Action string
+	Arg    string
+	Nonce  string
+}
+
+// IntArg возвращает аргумент как число (например, ID записи user_roles)
+func (id ID) IntArg() (int, error) {
+	n, err := strconv.Atoi(id.Arg)
+	if err != nil {
+		return 0, fmt.Errorf("custom id %s: invalid numeric argument %q", id.Action, id.Arg)
+	}
+	return n, nil
+}
+
+type Codec struct {
+	secret []byte
+}
+
+func NewCodec(secret string) *Codec {
+	return &Codec{secret: []byte(secret)}
+}
+
+// Encode собирает подписанный custom_id со случайным nonce.
+// Действие и аргумент с ":" - ErrSeparator, длиннее maxLength - ErrTooLong.
+func (c *Codec) Encode(action, arg string) (string, error) {
+	// Иначе Decode разобьёт поле на части и отклонит собственную кнопку бота
+	if strings.Contains(action, separator) || strings.Contains(arg, separator) {
+		return "", fmt.Errorf("%w: action %q, argument %q", ErrSeparator, action, arg)
+	}
+
+	nonceBytes := make([]byte, 6)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return "", fmt.Errorf("custom id nonce: %w", err)
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(nonceBytes)
+
+	payload := strings.Join([]string{version, action, arg, nonce}, separator)
+	raw := payload + separator + c.sign(payload)
+	if len(raw) > maxLength {
+		return "", fmt.Errorf("%w: action %s exceeds %d characters", ErrTooLong, action, maxLength)
+	}
+	return raw, nil
+}
+
+// Decode проверяет версию и подпись custom_id и возвращает его поля
+func (c *Codec) Decode(raw string) (ID, error) {
+	parts := strings.Split(raw, separator)
+	if len(parts) != 5 {
+		return ID{}, ErrMalformed
+	}
+	if parts[0] != version {
+		return ID{}, ErrUnsupportedVersion
+	}
+
+	payload := strings.Join(parts[:4], separator)
+	if !hmac.Equal([]byte(parts[4]), []byte(c.sign(payload))) {
+		return ID{}, ErrBadSignature
+	}
+
+	return ID{Action: parts[1], Arg: parts[2], Nonce: parts[3]}, nil
+}
+
+func (c *Codec) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:sigLength]
+}
+
+// Действия, которые бот кодирует в custom_id
+const (
+	ActionSelectRole      = "select_role"
+	ActionRemoveRole      = "remove_role"
+	ActionToggleRenewalDM = "toggle_renewal_dm"
+	ActionRenewYes        = "renew_yes"
+	ActionRenewNo         = "renew_no"
+	ActionJoinWaitlist    = "join_waitlist"
+	ActionWaitlistStatus  = "waitlist_status"
+	ActionApproveRequest  = "approve_request"
+	ActionDenyRequest     = "deny_request"
+	ActionDenyReason      = "deny_reason"
+	ActionDropReasonPick  = "drop_reason_pick"
+	ActionDropReason      = "drop_reason"
+	ActionRestoreRole     = "restore_role"
+
+	// Мастер настройки сервера (/setup)
+	ActionSetupChannel       = "setup_channel"
+	ActionSetupRoles         = "setup_roles"
+	ActionSetupPanelRoles    = "setup_panel_roles"
+	ActionSetupInactiveRole  = "setup_inactive_role"
+	ActionSetupDurations     = "setup_durations"
+	ActionSetupDurationsForm = "setup_durations_form"
+	ActionSetupCatalog       = "setup_catalog"
+	ActionSetupCatalogForm   = "setup_catalog_form"
+)
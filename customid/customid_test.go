@@ -0,0 +1,140 @@
+package customid
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	codec := NewCodec("secret")
+
+	tests := []struct {
+		action string
+		arg    string
+	}{
+		{ActionSelectRole, "moderator"},
+		{ActionRemoveRole, ""},
+		{ActionRenewYes, "12345"},
+		{ActionSetupCatalogForm, ""},
+	}
+
+	for _, tt := range tests {
+		raw, err := codec.Encode(tt.action, tt.arg)
+		if err != nil {
+			t.Fatalf("Encode(%q, %q): %v", tt.action, tt.arg, err)
+		}
+		if len(raw) > maxLength {
+			t.Errorf("Encode(%q, %q) = %d characters, want at most %d", tt.action, tt.arg, len(raw), maxLength)
+		}
+
+		id, err := codec.Decode(raw)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", raw, err)
+		}
+		if id.Action != tt.action || id.Arg != tt.arg || id.Nonce == "" {
+			t.Errorf("Decode(%q) = %+v, want action %q and arg %q", raw, id, tt.action, tt.arg)
+		}
+	}
+}
+
+func TestEncodeUsesFreshNonce(t *testing.T) {
+	codec := NewCodec("secret")
+
+	first, err := codec.Encode(ActionRemoveRole, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := codec.Encode(ActionRemoveRole, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("Encode returned the same id twice: %q", first)
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	codec := NewCodec("secret")
+
+	_, err := codec.Encode(ActionSelectRole, strings.Repeat("x", maxLength))
+	if !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode with a long argument: error = %v, want ErrTooLong", err)
+	}
+}
+
+func TestEncodeSeparator(t *testing.T) {
+	codec := NewCodec("secret")
+
+	tests := []struct {
+		action string
+		arg    string
+	}{
+		{ActionSelectRole, "a:b"},
+		{ActionSelectRole, ":"},
+		{"select:role", "1"},
+	}
+
+	for _, tt := range tests {
+		if _, err := codec.Encode(tt.action, tt.arg); !errors.Is(err, ErrSeparator) {
+			t.Errorf("Encode(%q, %q): error = %v, want ErrSeparator", tt.action, tt.arg, err)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	codec := NewCodec("secret")
+	valid, err := codec.Encode(ActionRenewNo, "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(valid, separator)
+
+	foreign, err := NewCodec("other").Encode(ActionRenewNo, "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{"empty", "", ErrMalformed},
+		{"legacy id", "renew_yes_42", ErrMalformed},
+		{"too many fields", valid + ":extra", ErrMalformed},
+		{"other version", strings.Join(append([]string{"v2"}, parts[1:]...), separator), ErrUnsupportedVersion},
+		{"changed argument", strings.Join([]string{parts[0], parts[1], "43", parts[3], parts[4]}, separator), ErrBadSignature},
+		{"changed action", strings.Join([]string{parts[0], ActionRenewYes, parts[2], parts[3], parts[4]}, separator), ErrBadSignature},
+		{"other secret", foreign, ErrBadSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := codec.Decode(tt.raw); !errors.Is(err, tt.want) {
+				t.Errorf("Decode(%q) error = %v, want %v", tt.raw, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntArg(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    int
+		wantErr bool
+	}{
+		{"42", 42, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"4.2", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ID{Action: ActionRenewYes, Arg: tt.arg}.IntArg()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("IntArg(%q) = %d, %v; want %d, error %t", tt.arg, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
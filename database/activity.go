@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// TouchActivity запоминает момент последней активности пользователя на сервере
+func (db *DB) TouchActivity(ctx context.Context, guildID, userID string, at time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO user_activity (guild_id, user_id, last_active_at) VALUES ($1, $2, $3)
+              ON CONFLICT (guild_id, user_id) DO UPDATE
+              SET last_active_at = GREATEST(user_activity.last_active_at, EXCLUDED.last_active_at)`
+	_, err := db.q.ExecContext(ctx, query, guildID, userID, at)
+	return err
+}
+
+// GetLastActivity возвращает момент последней активности на сервере; Valid = false, если её не было
+func (db *DB) GetLastActivity(ctx context.Context, guildID, userID string) (sql.NullTime, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT last_active_at FROM user_activity WHERE guild_id = $1 AND user_id = $2`
+	var lastActive sql.NullTime
+	err := db.q.QueryRowContext(ctx, query, guildID, userID).Scan(&lastActive)
+	if err == sql.ErrNoRows {
+		return sql.NullTime{}, nil
+	}
+	return lastActive, err
+}
@@ -1,108 +1,347 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"neble_2/logging"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
+// queryer - общее подмножество *sql.DB и *sql.Tx, через которое работают методы хранилища
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type DB struct {
 	*sql.DB
+	q            queryer
 	statsUpdater func()
+	roleFreed    func(ctx context.Context, roleID string)
+	queryTimeout time.Duration
+	tx           *txState
 }
 
-func New(connectionString string, statsUpdater func()) (*DB, error) {
+func New(connectionString string, queryTimeout time.Duration, statsUpdater func()) (*DB, error) {
 	db, err := sql.Open("postgres", connectionString)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
 		return nil, err
 	}
 
-	log.Println("Successfully connected to PostgreSQL")
-	return &DB{db, statsUpdater}, nil
+	slog.Info("Connected to PostgreSQL")
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate database: %w", err)
+	}
+	return &DB{DB: db, q: instrumentedQueryer{q: db}, statsUpdater: statsUpdater, queryTimeout: queryTimeout}, nil
+}
+
+// SetRoleFreedHook задаёт функцию, которую база вызывает, когда у роли освобождается место
+// (запись деактивирована пользователем, по таймауту или при сверке). Хук получает
+// контекст операции без её отмены - с полями логов того, кто освободил место.
+func (db *DB) SetRoleFreedHook(hook func(ctx context.Context, roleID string)) {
+	db.roleFreed = hook
 }
 
-func (db *DB) AddUserRole(userID, userName, roleID, roleName string, expiresAt time.Time) error {
-	query := `INSERT INTO user_roles (user_id, user_name, role_id, role_name, expires_at, message_id) 
-              VALUES ($1, $2, $3, $4, $5, '')`
-	result, err := db.Exec(query, userID, userName, roleID, roleName, expiresAt)
+// userRoleColumns - колонки user_roles в том порядке, в котором их читает scanUserRole
+const userRoleColumns = `id, guild_id, user_id, user_name, role_id, role_name, created_at, expires_at, is_active, renewal_status, renewal_count, grace_until, drop_reason, deactivated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUserRole(row rowScanner) (*UserRole, error) {
+	var role UserRole
+	err := row.Scan(&role.ID, &role.GuildID, &role.UserID, &role.UserName, &role.RoleID, &role.RoleName,
+		&role.CreatedAt, &role.ExpiresAt, &role.IsActive, &role.RenewalStatus, &role.RenewalCount, &role.GraceUntil,
+		&role.DropReason, &role.DeactivatedAt)
 	if err != nil {
-		log.Printf("Error inserting user role: %v", err)
-		return err
+		return nil, err
 	}
+	return &role, nil
+}
 
-	rows, _ := result.RowsAffected()
-	log.Printf("Successfully inserted role for user %s, role %s, affected rows: %d", userName, roleName, rows)
+// withTimeout ограничивает отдельный запрос, чтобы зависшая база не блокировала вызывающего
+func (db *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.queryTimeout)
+}
+
+func (db *DB) GetRoleByID(ctx context.Context, id int) (*UserRole, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userRoleColumns + `
+              FROM user_roles WHERE id = $1`
+
+	role, err := scanUserRole(db.q.QueryRowContext(ctx, query, id))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("role with ID %d: %w", id, ErrNotFound)
+		}
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// ClaimExpiredRoles атомарно забирает истёкшие записи в обработку, сразу переводя их
+// в "waiting_response". Параллельные запуски и другие реплики не получат те же записи.
+func (db *DB) ClaimExpiredRoles(ctx context.Context, guildID string) ([]UserRole, error) {
+	query := `UPDATE user_roles
+              SET renewal_status = 'waiting_response', renewal_requested_at = NOW()
+              WHERE id IN (
+                  SELECT id FROM user_roles
+                  WHERE guild_id = $1 AND expires_at < NOW() AND is_active = true AND renewal_status = 'pending'
+                  FOR UPDATE SKIP LOCKED
+              )
+              RETURNING ` + userRoleColumns
+	roles, err := db.queryUserRoles(ctx, query, guildID)
+	if err != nil {
+		return nil, err
+	}
 
-	if db.statsUpdater != nil {
-		go db.statsUpdater()
+	slog.InfoContext(ctx, "Claimed expired roles", logging.GuildID(guildID), "count", len(roles))
+	return roles, nil
+}
+
+// ReleaseRenewalClaim возвращает запись в "pending", если вопрос о продлении не удалось отправить
+func (db *DB) ReleaseRenewalClaim(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE user_roles
+              SET renewal_status = 'pending', renewal_requested_at = NULL
+              WHERE id = $1 AND renewal_status = 'waiting_response'`
+	_, err := db.q.ExecContext(ctx, query, id)
+	return err
+}
+
+// ClaimTimedOutRenewals атомарно деактивирует записи, на вопрос о продлении которых
+// не ответили за timeout, и возвращает их для снятия роли в Discord
+func (db *DB) ClaimTimedOutRenewals(ctx context.Context, guildID string, timeout time.Duration) ([]UserRole, error) {
+	query := `UPDATE user_roles
+              SET is_active = false, renewal_status = 'rejected', deactivated_at = NOW()
+              WHERE id IN (
+                  SELECT id FROM user_roles
+                  WHERE guild_id = $1 AND is_active = true AND renewal_status = 'waiting_response'
+                    AND renewal_requested_at < $2
+                  FOR UPDATE SKIP LOCKED
+              )
+              RETURNING ` + userRoleColumns
+	roles, err := db.queryUserRoles(ctx, query, guildID, time.Now().Add(-timeout))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.endVoiceRoles(ctx, roles); err != nil {
+		return nil, err
+	}
+
+	if len(roles) > 0 {
+		db.notifyStats()
+	}
+	for _, role := range roles {
+		db.notifyRoleFreed(ctx, role.RoleID)
+	}
+	return roles, nil
+}
+
+// ClaimGraceRenewals переводит записи, на вопрос о продлении которых не ответили за timeout,
+// в льготный период: роль ещё числится за пользователем и восстанавливается одним нажатием
+func (db *DB) ClaimGraceRenewals(ctx context.Context, guildID string, timeout, grace time.Duration) ([]UserRole, error) {
+	query := `UPDATE user_roles
+              SET renewal_status = 'grace', grace_until = $3
+              WHERE id IN (
+                  SELECT id FROM user_roles
+                  WHERE guild_id = $1 AND is_active = true AND renewal_status = 'waiting_response'
+                    AND renewal_requested_at < $2
+                  FOR UPDATE SKIP LOCKED
+              )
+              RETURNING ` + userRoleColumns
+	now := time.Now()
+	roles, err := db.queryUserRoles(ctx, query, guildID, now.Add(-timeout), now.Add(grace))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(roles) > 0 {
+		db.notifyStats()
+	}
+	return roles, nil
+}
+
+// ListUnnotifiedGraceRoles возвращает записи в льготном периоде без сообщения с кнопкой
+// восстановления: его не удалось отправить, и планировщик пробует снова
+func (db *DB) ListUnnotifiedGraceRoles(ctx context.Context, guildID string) ([]UserRole, error) {
+	query := `SELECT ` + userRoleColumns + `
+              FROM user_roles
+              WHERE guild_id = $1 AND is_active = true AND renewal_status = 'grace'
+                AND grace_until > NOW() AND COALESCE(message_id, '') = ''`
+	return db.queryUserRoles(ctx, query, guildID)
+}
+
+// ClaimExpiredGrace атомарно деактивирует записи, льготный период которых истёк
+func (db *DB) ClaimExpiredGrace(ctx context.Context, guildID string) ([]UserRole, error) {
+	query := `UPDATE user_roles
+              SET is_active = false, renewal_status = 'rejected', deactivated_at = NOW()
+              WHERE id IN (
+                  SELECT id FROM user_roles
+                  WHERE guild_id = $1 AND is_active = true AND renewal_status = 'grace' AND grace_until < NOW()
+                  FOR UPDATE SKIP LOCKED
+              )
+              RETURNING ` + userRoleColumns
+	roles, err := db.queryUserRoles(ctx, query, guildID)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.endVoiceRoles(ctx, roles); err != nil {
+		return nil, err
+	}
+
+	if len(roles) > 0 {
+		db.notifyStats()
+	}
+	for _, role := range roles {
+		db.notifyRoleFreed(ctx, role.RoleID)
+	}
+	return roles, nil
+}
+
+// endVoiceRoles переносит голосовые сессии владельцев снятых записей roles на время без роли
+func (db *DB) endVoiceRoles(ctx context.Context, roles []UserRole) error {
+	for _, role := range roles {
+		if err := db.switchVoiceSessionRole(ctx, role.GuildID, role.UserID, "", ""); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (db *DB) GetExpiredRoles() ([]UserRole, error) {
-	query := `SELECT id, user_id, user_name, role_id, role_name, created_at, expires_at, is_active, renewal_status
-              FROM user_roles 
-              WHERE expires_at < NOW() AND is_active = true AND renewal_status = 'pending'`
+// GetActiveRoles возвращает все активные записи сервера
+func (db *DB) GetActiveRoles(ctx context.Context, guildID string) ([]UserRole, error) {
+	query := `SELECT ` + userRoleColumns + `
+              FROM user_roles WHERE guild_id = $1 AND is_active = true
+              ORDER BY role_name, user_name`
+	return db.queryUserRoles(ctx, query, guildID)
+}
 
-	rows, err := db.Query(query)
+// GetUserRoleHistory возвращает все записи пользователя на сервере, от новых к старым
+func (db *DB) GetUserRoleHistory(ctx context.Context, guildID, userID string) ([]UserRole, error) {
+	query := `SELECT ` + userRoleColumns + `
+              FROM user_roles WHERE guild_id = $1 AND user_id = $2
+              ORDER BY created_at DESC, id DESC`
+	return db.queryUserRoles(ctx, query, guildID, userID)
+}
+
+// CountActiveRoles считает активные записи по всем серверам и ролям
+func (db *DB) CountActiveRoles(ctx context.Context) ([]ActiveRoleCount, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT guild_id, role_id, role_name, COUNT(*)
+              FROM user_roles
+              WHERE is_active = true
+              GROUP BY guild_id, role_id, role_name
+              ORDER BY guild_id, role_id`
+	rows, err := db.q.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var roles []UserRole
+	var counts []ActiveRoleCount
 	for rows.Next() {
-		var role UserRole
-		err := rows.Scan(&role.ID, &role.UserID, &role.UserName, &role.RoleID, &role.RoleName,
-			&role.CreatedAt, &role.ExpiresAt, &role.IsActive, &role.RenewalStatus)
-		if err != nil {
+		var c ActiveRoleCount
+		if err := rows.Scan(&c.GuildID, &c.RoleID, &c.RoleName, &c.Count); err != nil {
 			return nil, err
 		}
-		roles = append(roles, role)
+		counts = append(counts, c)
 	}
+	return counts, rows.Err()
+}
 
-	log.Printf("Query returned %d expired roles", len(roles))
-	return roles, nil
+// GetDropReasonStats считает причины отказа от ролей на сервере, указанные с момента since
+func (db *DB) GetDropReasonStats(ctx context.Context, guildID string, since time.Time) ([]DropReasonStat, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT role_name, drop_reason, COUNT(*)
+              FROM user_roles
+              WHERE guild_id = $1 AND drop_reason <> '' AND deactivated_at >= $2
+              GROUP BY role_name, drop_reason
+              ORDER BY role_name, COUNT(*) DESC, drop_reason`
+	rows, err := db.q.QueryContext(ctx, query, guildID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DropReasonStat
+	for rows.Next() {
+		var stat DropReasonStat
+		if err := rows.Scan(&stat.RoleName, &stat.Reason, &stat.Count); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
 }
 
-func (db *DB) GetRoleByID(id int) (*UserRole, error) {
-	query := `SELECT id, user_id, user_name, role_id, role_name, created_at, expires_at, is_active, renewal_status
-              FROM user_roles WHERE id = $1`
+// GetStaleRenewalMessages возвращает записи сервера, у которых осталось сообщение о продлении,
+// хотя ответа уже никто не ждёт
+func (db *DB) GetStaleRenewalMessages(ctx context.Context, guildID string) ([]UserRole, error) {
+	query := `SELECT ` + userRoleColumns + `
+              FROM user_roles
+              WHERE guild_id = $1 AND message_id <> ''
+                AND (is_active = false OR renewal_status NOT IN ('waiting_response', 'grace'))`
+	return db.queryUserRoles(ctx, query, guildID)
+}
 
-	var role UserRole
-	err := db.QueryRow(query, id).Scan(
-		&role.ID,
-		&role.UserID,
-		&role.UserName,
-		&role.RoleID,
-		&role.RoleName,
-		&role.CreatedAt,
-		&role.ExpiresAt,
-		&role.IsActive,
-		&role.RenewalStatus,
-	)
+func (db *DB) queryUserRoles(ctx context.Context, query string, args ...any) ([]UserRole, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
+	rows, err := db.q.QueryContext(ctx, query, args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("role with ID %d not found", id)
-		}
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &role, nil
+	var roles []UserRole
+	for rows.Next() {
+		role, err := scanUserRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *role)
+	}
+
+	return roles, rows.Err()
 }
 
-func (db *DB) UpdateRenewalStatus(id int, status string) error {
+func (db *DB) UpdateRenewalStatus(ctx context.Context, id int, status string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	validStatuses := map[string]bool{
 		"pending":          true,
 		"waiting_response": true,
+		"grace":            true,
 		"confirmed":        true,
 		"rejected":         true,
 	}
@@ -112,45 +351,120 @@ func (db *DB) UpdateRenewalStatus(id int, status string) error {
 	}
 
 	query := `UPDATE user_roles SET renewal_status = $1 WHERE id = $2`
-	_, err := db.Exec(query, status, id)
+	_, err := db.q.ExecContext(ctx, query, status, id)
 	return err
 }
 
-func (db *DB) ExtendRole(id int, newExpiresAt time.Time) error {
-	query := `UPDATE user_roles 
-              SET expires_at = $1, is_active = true, renewal_status = 'pending' 
-              WHERE id = $2`
-	_, err := db.Exec(query, newExpiresAt, id)
+// ExtendRole продлевает активную запись до newExpiresAt, если её статус продления всё ещё
+// status - тот, что видел вызывающий. Если запись тем временем сняли или перевели в другой
+// статус, возвращает ErrNotFound и ничего не меняет.
+func (db *DB) ExtendRole(ctx context.Context, id int, status string, newExpiresAt time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	if db.statsUpdater != nil {
-		go db.statsUpdater()
+	query := `UPDATE user_roles 
+              SET expires_at = $1, renewal_status = 'pending',
+                  renewal_count = renewal_count + 1, grace_until = NULL
+              WHERE id = $2 AND is_active = true AND renewal_status = $3`
+	result, err := db.q.ExecContext(ctx, query, newExpiresAt, id, status)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("active role with ID %d and status %s: %w", id, status, ErrNotFound)
 	}
 
-	return err
+	db.notifyStats()
+	return nil
+}
+
+func (db *DB) DeactivateRole(ctx context.Context, id int) error {
+	return db.DeactivateRoleWithReason(ctx, id, "")
 }
 
-func (db *DB) DeactivateRole(id int) error {
+// DeactivateRoleWithReason снимает роль и сохраняет причину, которую указал пользователь
+func (db *DB) DeactivateRoleWithReason(ctx context.Context, id int, reason string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	query := `UPDATE user_roles 
-              SET is_active = false, renewal_status = 'rejected' 
-              WHERE id = $1`
-	_, err := db.Exec(query, id)
+              SET is_active = false, renewal_status = 'rejected',
+                  drop_reason = $2, deactivated_at = NOW()
+              WHERE id = $1
+              RETURNING guild_id, user_id, role_id`
+	var guildID, userID, roleID string
+	err := db.q.QueryRowContext(ctx, query, id, reason).Scan(&guildID, &userID, &roleID)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("role with ID %d not found", id)
+	}
+	if err == nil {
+		err = db.switchVoiceSessionRole(ctx, guildID, userID, "", "")
+	}
 
-	if db.statsUpdater != nil {
-		go db.statsUpdater()
+	db.notifyStats()
+	if err == nil {
+		db.notifyRoleFreed(ctx, roleID)
 	}
 
 	return err
 }
 
-func (db *DB) GetActiveRoleByUserID(userID string) (*UserRole, error) {
-	query := `SELECT id, user_id, user_name, role_id, role_name, created_at, expires_at, is_active, renewal_status
-              FROM user_roles WHERE user_id = $1 AND is_active = true`
+// ReactivateRole возвращает в силу запись, снятую DeactivateRoleWithReason, со статусом
+// продления status - если снять роль в Discord не удалось
+func (db *DB) ReactivateRole(ctx context.Context, id int, status string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE user_roles
+              SET is_active = true, renewal_status = $2, drop_reason = '', deactivated_at = NULL
+              WHERE id = $1 AND is_active = false
+              RETURNING guild_id, user_id, role_id, role_name`
+	var guildID, userID, roleID, roleName string
+	err := db.q.QueryRowContext(ctx, query, id, status).Scan(&guildID, &userID, &roleID, &roleName)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("role with ID %d not found", id)
+	}
+	if err != nil {
+		return mapConstraintError(err)
+	}
+	if err := db.switchVoiceSessionRole(ctx, guildID, userID, roleID, roleName); err != nil {
+		return err
+	}
 
-	var role UserRole
-	err := db.QueryRow(query, userID).Scan(
-		&role.ID, &role.UserID, &role.UserName, &role.RoleID, &role.RoleName,
-		&role.CreatedAt, &role.ExpiresAt, &role.IsActive, &role.RenewalStatus,
-	)
+	db.notifyStats()
+	return nil
+}
+
+// DeleteUserRole удаляет только что созданную запись, если выдать роль в Discord
+// не удалось. Место в роли не считается освободившимся: его никто не занимал.
+func (db *DB) DeleteUserRole(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var guildID, userID string
+	err := db.q.QueryRowContext(ctx, `DELETE FROM user_roles WHERE id = $1 RETURNING guild_id, user_id`, id).Scan(&guildID, &userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := db.switchVoiceSessionRole(ctx, guildID, userID, "", ""); err != nil {
+		return err
+	}
+
+	db.notifyStats()
+	return nil
+}
+
+func (db *DB) GetActiveRoleByUserID(ctx context.Context, guildID, userID string) (*UserRole, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userRoleColumns + `
+              FROM user_roles WHERE guild_id = $1 AND user_id = $2 AND is_active = true`
+
+	role, err := scanUserRole(db.q.QueryRowContext(ctx, query, guildID, userID))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -159,28 +473,34 @@ func (db *DB) GetActiveRoleByUserID(userID string) (*UserRole, error) {
 		return nil, err
 	}
 
-	return &role, nil
+	return role, nil
 }
 
-func (db *DB) RemoveUserRole(userID string) error {
+func (db *DB) RemoveUserRole(ctx context.Context, guildID, userID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	query := `UPDATE user_roles 
               SET is_active = false, renewal_status = 'changed' 
-              WHERE user_id = $1`
-	result, err := db.Exec(query, userID)
+              WHERE guild_id = $1 AND user_id = $2`
+	result, err := db.q.ExecContext(ctx, query, guildID, userID)
 	if err != nil {
 		return err
 	}
 
 	rows, _ := result.RowsAffected()
-	log.Printf("Removed active role for user %s, affected rows: %d", userID, rows)
+	slog.InfoContext(ctx, "Removed active role", logging.GuildID(guildID), logging.UserID(userID), "rows", rows)
 	return nil
 }
 
-func (db *DB) GetActiveRoleIDByUserID(userID string) (string, error) {
-	query := `SELECT role_id FROM user_roles WHERE user_id = $1 AND is_active = true`
+func (db *DB) GetActiveRoleIDByUserID(ctx context.Context, guildID, userID string) (string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT role_id FROM user_roles WHERE guild_id = $1 AND user_id = $2 AND is_active = true`
 
 	var roleID string
-	err := db.QueryRow(query, userID).Scan(&roleID)
+	err := db.q.QueryRowContext(ctx, query, guildID, userID).Scan(&roleID)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -192,62 +512,99 @@ func (db *DB) GetActiveRoleIDByUserID(userID string) (string, error) {
 	return roleID, nil
 }
 
-// GetUserRole получает любую запись о пользователе (активную или нет)
-func (db *DB) GetUserRole(userID string) (*UserRole, error) {
-	query := `SELECT id, user_id, user_name, role_id, role_name, created_at, expires_at, is_active, renewal_status
-              FROM user_roles WHERE user_id = $1
-              ORDER BY created_at DESC LIMIT 1`
-
-	var role UserRole
-	err := db.QueryRow(query, userID).Scan(
-		&role.ID, &role.UserID, &role.UserName, &role.RoleID, &role.RoleName,
-		&role.CreatedAt, &role.ExpiresAt, &role.IsActive, &role.RenewalStatus,
-	)
-
+// AssignRole выдаёт пользователю роль новой записью и возвращает её. Прошлые записи
+// остаются как история вместе с причинами отказа. Если у пользователя уже есть
+// активная роль, возвращает ErrActiveRoleExists.
+func (db *DB) AssignRole(ctx context.Context, guildID, userID, userName, roleID, roleName string, expiresAt time.Time) (*UserRole, error) {
+	// Проверяем заранее: нарушение индекса прервало бы всю транзакцию вызывающего,
+	// а индекс остаётся защитой от гонки
+	active, err := db.GetActiveRoleByUserID(ctx, guildID, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user %s not found", userID)
-		}
 		return nil, err
 	}
+	if active != nil {
+		return nil, ErrActiveRoleExists
+	}
 
-	return &role, nil
-}
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-// UpdateUserRole обновляет существующую запись пользователя
-func (db *DB) UpdateUserRole(userID, roleID, roleName string, expiresAt time.Time) error {
-	query := `UPDATE user_roles 
-              SET role_id = $1, role_name = $2, expires_at = $3, 
-                  is_active = true, renewal_status = 'pending', created_at = NOW(), message_id = ''
-              WHERE user_id = $4`
-	result, err := db.Exec(query, roleID, roleName, expiresAt, userID)
+	query := `INSERT INTO user_roles (guild_id, user_id, user_name, role_id, role_name, expires_at, message_id)
+              VALUES ($1, $2, $3, $4, $5, $6, '')
+              RETURNING ` + userRoleColumns
+	role, err := scanUserRole(db.q.QueryRowContext(ctx, query, guildID, userID, userName, roleID, roleName, expiresAt))
 	if err != nil {
-		return err
+		return nil, mapConstraintError(err)
+	}
+	if err := db.switchVoiceSessionRole(ctx, guildID, userID, roleID, roleName); err != nil {
+		return nil, err
 	}
 
-	rows, _ := result.RowsAffected()
-	log.Printf("Updated role for user %s, affected rows: %d", userID, rows)
+	slog.InfoContext(ctx, "Inserted user role", logging.GuildID(guildID), logging.UserID(userID), "role_id", roleID, logging.AssignmentID(role.ID))
+	db.notifyStats()
+	return role, nil
+}
 
-	if db.statsUpdater != nil {
-		go db.statsUpdater()
-	}
+// SetRenewalMessage сохраняет канал и ID сообщения о продлении (канал может быть личным)
+func (db *DB) SetRenewalMessage(ctx context.Context, roleID int, channelID, messageID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	return nil
+	query := `UPDATE user_roles SET message_channel_id = $1, message_id = $2 WHERE id = $3`
+	_, err := db.q.ExecContext(ctx, query, channelID, messageID, roleID)
+	return err
 }
 
-func (db *DB) SetRenewalMessageID(roleID int, messageID string) error {
-	query := `UPDATE user_roles SET message_id = $1 WHERE id = $2`
-	_, err := db.Exec(query, messageID, roleID)
+// ClearRenewalMessage забывает сообщение о продлении после его удаления
+func (db *DB) ClearRenewalMessage(ctx context.Context, roleID int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE user_roles SET message_channel_id = '', message_id = '' WHERE id = $1`
+	_, err := db.q.ExecContext(ctx, query, roleID)
 	return err
 }
 
-// Добавляем метод для получения ID сообщения
-func (db *DB) GetRenewalMessageID(roleID int) (string, error) {
-	query := `SELECT message_id FROM user_roles WHERE id = $1`
-	var messageID string
-	err := db.QueryRow(query, roleID).Scan(&messageID)
+// GetRenewalMessage возвращает канал и ID сообщения о продлении
+func (db *DB) GetRenewalMessage(ctx context.Context, roleID int) (string, string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT message_channel_id, message_id FROM user_roles WHERE id = $1`
+	var channelID, messageID string
+	err := db.q.QueryRowContext(ctx, query, roleID).Scan(&channelID, &messageID)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	return messageID, nil
+	return channelID, messageID, nil
+}
+
+// GetRenewalDMPreference возвращает настройку пользователя для уведомлений в ЛС.
+// nil означает, что пользователь ничего не выбирал и действует настройка сервера.
+func (db *DB) GetRenewalDMPreference(ctx context.Context, userID string) (*bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT renewal_dm FROM user_settings WHERE user_id = $1`
+	var enabled bool
+	err := db.q.QueryRowContext(ctx, query, userID).Scan(&enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &enabled, nil
+}
+
+// SetRenewalDMPreference сохраняет настройку уведомлений в ЛС для пользователя
+func (db *DB) SetRenewalDMPreference(ctx context.Context, userID string, enabled bool) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO user_settings (user_id, renewal_dm, updated_at)
+              VALUES ($1, $2, NOW())
+              ON CONFLICT (user_id) DO UPDATE SET renewal_dm = EXCLUDED.renewal_dm, updated_at = NOW()`
+	_, err := db.q.ExecContext(ctx, query, userID, enabled)
+	return err
 }
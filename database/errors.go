@@ -0,0 +1,36 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// ErrActiveRoleExists - у пользователя уже есть активная роль (нарушен
+// уникальный индекс idx_user_roles_one_active_guild)
+var ErrActiveRoleExists = errors.New("user already has an active role")
+
+// ErrPendingRequestExists - у пользователя уже есть рассматриваемая заявка на роль
+// (нарушен уникальный индекс idx_role_requests_one_pending_guild)
+var ErrPendingRequestExists = errors.New("user already has a pending role request")
+
+// ErrNotFound - запись не найдена
+var ErrNotFound = errors.New("not found")
+
+const uniqueViolation = "23505"
+
+// mapConstraintError переводит нарушения ограничений Postgres в ошибки пакета
+func mapConstraintError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return err
+	}
+
+	switch pqErr.Constraint {
+	case "idx_user_roles_one_active_guild":
+		return ErrActiveRoleExists
+	case "idx_role_requests_one_pending_guild":
+		return ErrPendingRequestExists
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"neble_2/logging"
+)
+
+// GuildSettings - настройки сервера из guild_settings. Пустые строки и nil
+// означают, что используется значение по умолчанию из окружения.
+//
+// Языка сообщений среди настроек нет: все тексты бота написаны по-русски, а перевод
+// требует вынести их из обработчиков в каталог сообщений. До этого колонка language
+// ничего не меняла и удалена миграцией 0003.
+type GuildSettings struct {
+	GuildID               string
+	RoleChannelID         string
+	NotificationChannelID string
+	StatsChannelID        string
+	StaffChannelID        string
+	InactiveRoleID        string
+	RoleCatalog           json.RawMessage // JSON-каталог ролей в формате ROLE_CATALOG_FILE
+	RoleDuration          string
+	RenewalDuration       string
+}
+
+const guildSettingsColumns = `guild_id, role_channel_id, notification_channel_id, stats_channel_id, staff_channel_id,
+              inactive_role_id, role_catalog, role_duration, renewal_duration`
+
+// GetGuildSettings возвращает настройки всех серверов, к которым подключён бот
+func (db *DB) GetGuildSettings(ctx context.Context) ([]GuildSettings, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.q.QueryContext(ctx, `SELECT `+guildSettingsColumns+` FROM guild_settings ORDER BY joined_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []GuildSettings
+	for rows.Next() {
+		var gs GuildSettings
+		var catalog []byte
+		err := rows.Scan(&gs.GuildID, &gs.RoleChannelID, &gs.NotificationChannelID, &gs.StatsChannelID,
+			&gs.StaffChannelID, &gs.InactiveRoleID, &catalog, &gs.RoleDuration, &gs.RenewalDuration)
+		if err != nil {
+			return nil, err
+		}
+		gs.RoleCatalog = catalog
+		settings = append(settings, gs)
+	}
+	return settings, rows.Err()
+}
+
+// CreateGuildSettings регистрирует сервер с настройками по умолчанию.
+// Возвращает false, если сервер уже зарегистрирован.
+func (db *DB) CreateGuildSettings(ctx context.Context, guildID string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.q.ExecContext(ctx, `INSERT INTO guild_settings (guild_id) VALUES ($1) ON CONFLICT (guild_id) DO NOTHING`, guildID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// AdoptLegacyRows привязывает к серверу записи, созданные до поддержки нескольких серверов
+func (db *DB) AdoptLegacyRows(ctx context.Context, guildID string) error {
+	for _, table := range []string{"user_roles", "role_requests", "user_activity", "voice_sessions"} {
+		if err := db.adoptLegacyTable(ctx, table, guildID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) adoptLegacyTable(ctx context.Context, table, guildID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.q.ExecContext(ctx, `UPDATE `+table+` SET guild_id = $1 WHERE guild_id = ''`, guildID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		slog.InfoContext(ctx, "Assigned legacy rows to guild", logging.GuildID(guildID), "table", table, "rows", rows)
+	}
+	return nil
+}
+
+// UpdateGuildSettings сохраняет настройки сервера целиком
+func (db *DB) UpdateGuildSettings(ctx context.Context, gs GuildSettings) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	// Пустой каталог хранится как NULL - это значит "каталог по умолчанию"
+	var catalog []byte
+	if len(gs.RoleCatalog) > 0 {
+		catalog = gs.RoleCatalog
+	}
+
+	query := `UPDATE guild_settings
+              SET role_channel_id = $2, notification_channel_id = $3, stats_channel_id = $4, staff_channel_id = $5,
+                  inactive_role_id = $6, role_catalog = $7, role_duration = $8, renewal_duration = $9,
+                  updated_at = NOW()
+              WHERE guild_id = $1`
+	_, err := db.q.ExecContext(ctx, query, gs.GuildID, gs.RoleChannelID, gs.NotificationChannelID, gs.StatsChannelID,
+		gs.StaffChannelID, gs.InactiveRoleID, catalog, gs.RoleDuration, gs.RenewalDuration)
+	return err
+}
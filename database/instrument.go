@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// instrumentedQueryer логирует запросы с полями из контекста и учитывает их в метриках.
+// Каждый запрос виден в логах на уровне DEBUG, ошибки - на WARN; так запросы к БД
+// попадают в логи с correlation_id взаимодействия или задачи, которые их вызвали.
+type instrumentedQueryer struct {
+	q queryer
+}
+
+func (iq instrumentedQueryer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	started := time.Now()
+	result, err := iq.q.ExecContext(ctx, query, args...)
+	observeQuery(ctx, query, started, err)
+	return result, err
+}
+
+func (iq instrumentedQueryer) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	started := time.Now()
+	rows, err := iq.q.QueryContext(ctx, query, args...)
+	observeQuery(ctx, query, started, err)
+	return rows, err
+}
+
+func (iq instrumentedQueryer) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	started := time.Now()
+	row := iq.q.QueryRowContext(ctx, query, args...)
+	// Отсутствие строк - обычный результат, а не ошибка запроса
+	err := row.Err()
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	observeQuery(ctx, query, started, err)
+	return row
+}
+
+func observeQuery(ctx context.Context, query string, started time.Time, err error) {
+	elapsed := time.Since(started)
+	statement := statementType(query)
+	metrics.DBQueryDuration.WithLabelValues(statement).Observe(elapsed.Seconds())
+	if err != nil {
+		metrics.DBQueryErrors.WithLabelValues(statement).Inc()
+	}
+
+	if err == nil && !slog.Default().Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []any{
+		"query", strings.Join(strings.Fields(query), " "),
+		"duration_ms", elapsed.Milliseconds(),
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Database query failed", append(attrs, logging.Err(err))...)
+		return
+	}
+	slog.DebugContext(ctx, "Database query", attrs...)
+}
+
+// statementType - первое слово запроса (select, insert, update, ...) как метка метрик
+func statementType(query string) string {
+	query = strings.TrimSpace(query)
+	end := strings.IndexFunc(query, unicode.IsSpace)
+	if end < 0 {
+		end = len(query)
+	}
+	return strings.ToLower(query[:end])
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"neble_2/logging"
+	"sync"
+)
+
+// LeaderElector выбирает единственный экземпляр бота для запуска фоновых задач
+// с помощью advisory-блокировки Postgres. Блокировка живёт, пока открыто
+// выделенное соединение, поэтому упавшая реплика автоматически теряет лидерство.
+type LeaderElector struct {
+	db   *DB
+	key  int64
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+func (db *DB) NewLeaderElector(key int64) *LeaderElector {
+	return &LeaderElector{db: db, key: key}
+}
+
+// IsLeader проверяет, удерживает ли этот экземпляр блокировку, и пытается её захватить, если нет
+func (le *LeaderElector) IsLeader(ctx context.Context) bool {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	if le.conn != nil {
+		if err := le.conn.PingContext(ctx); err == nil {
+			return true
+		}
+		slog.WarnContext(ctx, "Lost scheduler leadership: connection holding the advisory lock is broken", "lock", le.key)
+		le.conn.Close()
+		le.conn = nil
+	}
+
+	conn, err := le.db.Conn(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting connection for leader election", logging.Err(err))
+		return false
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, le.key).Scan(&acquired)
+	if err != nil || !acquired {
+		if err != nil {
+			slog.ErrorContext(ctx, "Error acquiring advisory lock", "lock", le.key, logging.Err(err))
+		}
+		conn.Close()
+		return false
+	}
+
+	slog.InfoContext(ctx, "Acquired scheduler leadership", "lock", le.key)
+	le.conn = conn
+	return true
+}
+
+// Release отпускает блокировку, чтобы другая реплика могла сразу стать лидером
+func (le *LeaderElector) Release() {
+	le.mu.Lock()
+	defer le.mu.Unlock()
+
+	if le.conn == nil {
+		return
+	}
+
+	if _, err := le.conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, le.key); err != nil {
+		slog.Error("Error releasing advisory lock", "lock", le.key, logging.Err(err))
+	}
+	le.conn.Close()
+	le.conn = nil
+	slog.Info("Released scheduler leadership", "lock", le.key)
+}
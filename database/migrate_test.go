@@ -0,0 +1,27 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	if migrations[0].version != 1 || migrations[0].name != "baseline" {
+		t.Errorf("first migration = %04d_%s, want 0001_baseline", migrations[0].version, migrations[0].name)
+	}
+	for i, m := range migrations {
+		if i > 0 && m.version <= migrations[i-1].version {
+			t.Errorf("migration %04d_%s is out of order", m.version, m.name)
+		}
+		if strings.TrimSpace(m.sql) == "" {
+			t.Errorf("migration %04d_%s is empty", m.version, m.name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+-- Схема на момент появления миграций. Все операторы идемпотентны: базы, созданные
+-- через init.sql, доводятся до той же схемы.
+CREATE TABLE IF NOT EXISTS user_roles (
+    id SERIAL PRIMARY KEY,
+    guild_id VARCHAR(20) NOT NULL DEFAULT '',
+    user_id VARCHAR(20) NOT NULL,
+    user_name VARCHAR(100) NOT NULL,
+    role_id VARCHAR(20) NOT NULL,
+    role_name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    is_active BOOLEAN DEFAULT true,
+    renewal_status VARCHAR(20) DEFAULT 'pending',
+    message_id VARCHAR(20) DEFAULT '',
+    message_channel_id VARCHAR(20) DEFAULT '',
+    renewal_requested_at TIMESTAMP WITH TIME ZONE,
+    renewal_count INTEGER NOT NULL DEFAULT 0,
+    drop_reason TEXT NOT NULL DEFAULT '',
+    deactivated_at TIMESTAMP WITH TIME ZONE,
+    grace_until TIMESTAMP WITH TIME ZONE
+);
+
+-- Последняя активность пользователей (сообщения и голосовые каналы) для автопродления
+CREATE TABLE IF NOT EXISTS user_activity (
+    guild_id VARCHAR(20) NOT NULL DEFAULT '',
+    user_id VARCHAR(20) NOT NULL,
+    last_active_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Голосовые сессии; роль - активная у пользователя в момент входа в голос
+CREATE TABLE IF NOT EXISTS voice_sessions (
+    id SERIAL PRIMARY KEY,
+    guild_id VARCHAR(20) NOT NULL DEFAULT '',
+    user_id VARCHAR(20) NOT NULL,
+    user_name VARCHAR(100) NOT NULL,
+    role_id VARCHAR(20) NOT NULL DEFAULT '',
+    role_name VARCHAR(100) NOT NULL DEFAULT '',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Настройки серверов. Пустые значения означают значения по умолчанию из окружения
+CREATE TABLE IF NOT EXISTS guild_settings (
+    guild_id VARCHAR(20) PRIMARY KEY,
+    role_channel_id VARCHAR(20) NOT NULL DEFAULT '',
+    notification_channel_id VARCHAR(20) NOT NULL DEFAULT '',
+    stats_channel_id VARCHAR(20) NOT NULL DEFAULT '',
+    staff_channel_id VARCHAR(20) NOT NULL DEFAULT '',
+    inactive_role_id VARCHAR(20) NOT NULL DEFAULT '',
+    role_catalog JSONB,
+    role_duration VARCHAR(20) NOT NULL DEFAULT '',
+    renewal_duration VARCHAR(20) NOT NULL DEFAULT '',
+    language VARCHAR(8) NOT NULL DEFAULT '',
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Персональные настройки пользователей
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id VARCHAR(20) PRIMARY KEY,
+    renewal_dm BOOLEAN NOT NULL DEFAULT false,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Очереди на роли с ограниченным числом мест
+CREATE TABLE IF NOT EXISTS role_waitlist (
+    id SERIAL PRIMARY KEY,
+    user_id VARCHAR(20) NOT NULL,
+    user_name VARCHAR(100) NOT NULL,
+    role_id VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    notified_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (user_id, role_id)
+);
+
+-- Заявки на роли, которые выдаются только после одобрения модератором
+CREATE TABLE IF NOT EXISTS role_requests (
+    id SERIAL PRIMARY KEY,
+    guild_id VARCHAR(20) NOT NULL DEFAULT '',
+    user_id VARCHAR(20) NOT NULL,
+    user_name VARCHAR(100) NOT NULL,
+    role_id VARCHAR(20) NOT NULL,
+    role_name VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    decided_at TIMESTAMP WITH TIME ZONE,
+    decided_by VARCHAR(20) NOT NULL DEFAULT '',
+    deny_reason TEXT NOT NULL DEFAULT '',
+    message_id VARCHAR(20) NOT NULL DEFAULT ''
+);
+
+-- Очередь доставки вебхуков: по записи на каждое событие и подписанный на него вебхук.
+-- payload хранится как JSON, а не JSONB, чтобы тело запроса не менялось
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    webhook VARCHAR(100) NOT NULL,
+    event_id VARCHAR(32) NOT NULL,
+    event VARCHAR(20) NOT NULL,
+    payload JSON NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_expires_at ON user_roles(expires_at);
+CREATE INDEX IF NOT EXISTS idx_user_roles_user_id ON user_roles(user_id);
+CREATE INDEX IF NOT EXISTS idx_role_waitlist_role_id ON role_waitlist(role_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_voice_sessions_ended_at ON voice_sessions(ended_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(webhook, id) WHERE status = 'pending';
+
+-- Миграции для уже существующих баз
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS message_channel_id VARCHAR(20) DEFAULT '';
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS renewal_requested_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS renewal_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS drop_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS grace_until TIMESTAMP WITH TIME ZONE;
+
+-- Записи привязаны к серверу. Старые записи получают guild_id = '' и при запуске
+-- переходят серверу из GUILD_ID
+ALTER TABLE user_roles ADD COLUMN IF NOT EXISTS guild_id VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE role_requests ADD COLUMN IF NOT EXISTS guild_id VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE user_activity ADD COLUMN IF NOT EXISTS guild_id VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE voice_sessions ADD COLUMN IF NOT EXISTS guild_id VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE user_activity DROP CONSTRAINT IF EXISTS user_activity_pkey;
+DROP INDEX IF EXISTS idx_voice_sessions_one_open;
+DROP INDEX IF EXISTS idx_role_requests_one_pending;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_activity_guild_user ON user_activity(guild_id, user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_voice_sessions_one_open_guild ON voice_sessions(guild_id, user_id) WHERE ended_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_role_requests_one_pending_guild ON role_requests(guild_id, user_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_user_roles_guild_id ON user_roles(guild_id);
+
+-- Не больше одной активной роли на пользователя на сервере: все роли панели составляют одну группу.
+-- Перед созданием индекса оставляем активной только самую свежую запись пользователя
+UPDATE user_roles SET is_active = false, renewal_status = 'changed'
+WHERE is_active = true AND id NOT IN (
+    SELECT DISTINCT ON (guild_id, user_id) id FROM user_roles
+    WHERE is_active = true
+    ORDER BY guild_id, user_id, created_at DESC
+);
+DROP INDEX IF EXISTS idx_user_roles_one_active;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_roles_one_active_guild ON user_roles(guild_id, user_id) WHERE is_active = true;
@@ -0,0 +1,4 @@
+-- Вопросы о продлении, отправленные до появления renewal_requested_at, не попадали
+-- под таймаут и висели вечно. Отсчитываем их срок ответа от момента миграции.
+UPDATE user_roles SET renewal_requested_at = NOW()
+WHERE renewal_status = 'waiting_response' AND renewal_requested_at IS NULL;
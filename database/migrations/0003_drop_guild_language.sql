@@ -0,0 +1,2 @@
+-- Язык сообщений так и не начал использоваться: бот отвечает только по-русски
+ALTER TABLE guild_settings DROP COLUMN IF EXISTS language;
@@ -1,16 +1,90 @@
 package database
 
-import "time"
+import (
+	"database/sql"
+	"time"
+)
 
 type UserRole struct {
-	ID            int       `db:"id"`
-	UserID        string    `db:"user_id"`
-	UserName      string    `db:"user_name"`
-	RoleID        string    `db:"role_id"`
-	RoleName      string    `db:"role_name"`
-	CreatedAt     time.Time `db:"created_at"`
-	ExpiresAt     time.Time `db:"expires_at"`
-	IsActive      bool      `db:"is_active"`
-	RenewalStatus string    `db:"renewal_status"` // "pending", "waiting_response", "confirmed", "rejected"
-	MessageID     string    `db:"message_id"`
+	ID                 int          `db:"id"`
+	GuildID            string       `db:"guild_id"`
+	UserID             string       `db:"user_id"`
+	UserName           string       `db:"user_name"`
+	RoleID             string       `db:"role_id"`
+	RoleName           string       `db:"role_name"`
+	CreatedAt          time.Time    `db:"created_at"`
+	ExpiresAt          time.Time    `db:"expires_at"`
+	IsActive           bool         `db:"is_active"`
+	RenewalStatus      string       `db:"renewal_status"` // "pending", "waiting_response", "grace", "confirmed", "rejected"
+	MessageID          string       `db:"message_id"`
+	MessageChannelID   string       `db:"message_channel_id"`
+	RenewalRequestedAt sql.NullTime `db:"renewal_requested_at"`
+	RenewalCount       int          `db:"renewal_count"`
+	GraceUntil         sql.NullTime `db:"grace_until"` // до какого момента роль можно восстановить
+	DropReason         string       `db:"drop_reason"`
+	DeactivatedAt      sql.NullTime `db:"deactivated_at"`
+}
+
+// DropReasonStat - сколько раз роль сдавали с указанной причиной
+type DropReasonStat struct {
+	RoleName string
+	Reason   string
+	Count    int
+}
+
+// ActiveRoleCount - сколько активных записей у роли на сервере
+type ActiveRoleCount struct {
+	GuildID  string
+	RoleID   string
+	RoleName string
+	Count    int
+}
+
+// VoiceTotal - суммарное время в голосовых каналах роли или пользователя
+type VoiceTotal struct {
+	ID       string // ID роли или пользователя
+	Name     string
+	Duration time.Duration
+}
+
+// WaitlistEntry - место в очереди на роль с ограниченным числом мест
+type WaitlistEntry struct {
+	ID         int          `db:"id"`
+	UserID     string       `db:"user_id"`
+	UserName   string       `db:"user_name"`
+	RoleID     string       `db:"role_id"`
+	CreatedAt  time.Time    `db:"created_at"`
+	NotifiedAt sql.NullTime `db:"notified_at"` // когда пользователю сообщили о свободном месте
+	Position   int          `db:"-"`
+}
+
+// RoleRequest - заявка на роль, которую выдаёт модератор
+type RoleRequest struct {
+	ID         int          `db:"id"`
+	GuildID    string       `db:"guild_id"`
+	UserID     string       `db:"user_id"`
+	UserName   string       `db:"user_name"`
+	RoleID     string       `db:"role_id"`
+	RoleName   string       `db:"role_name"`
+	Status     string       `db:"status"` // "pending", "approved", "denied"
+	CreatedAt  time.Time    `db:"created_at"`
+	DecidedAt  sql.NullTime `db:"decided_at"`
+	DecidedBy  string       `db:"decided_by"`
+	DenyReason string       `db:"deny_reason"`
+	MessageID  string       `db:"message_id"` // карточка заявки в канале модераторов
+}
+
+// WebhookDelivery - событие о роли в очереди доставки одному вебхуку
+type WebhookDelivery struct {
+	ID            int          `db:"id"`
+	Webhook       string       `db:"webhook"` // имя вебхука из конфигурации
+	EventID       string       `db:"event_id"`
+	Event         string       `db:"event"`
+	Payload       []byte       `db:"payload"` // тело запроса; подписывается при каждой попытке
+	Status        string       `db:"status"`  // "pending", "delivered", "failed"
+	Attempts      int          `db:"attempts"`
+	NextAttemptAt time.Time    `db:"next_attempt_at"`
+	LastError     string       `db:"last_error"`
+	CreatedAt     time.Time    `db:"created_at"`
+	DeliveredAt   sql.NullTime `db:"delivered_at"`
 }
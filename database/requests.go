@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const roleRequestColumns = `id, guild_id, user_id, user_name, role_id, role_name, status, created_at, decided_at, decided_by, deny_reason, message_id`
+
+func scanRoleRequest(row rowScanner) (*RoleRequest, error) {
+	var req RoleRequest
+	err := row.Scan(&req.ID, &req.GuildID, &req.UserID, &req.UserName, &req.RoleID, &req.RoleName, &req.Status,
+		&req.CreatedAt, &req.DecidedAt, &req.DecidedBy, &req.DenyReason, &req.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// CreateRoleRequest создаёт заявку на роль. Если у пользователя уже есть
+// рассматриваемая заявка, возвращает ErrPendingRequestExists.
+func (db *DB) CreateRoleRequest(ctx context.Context, guildID, userID, userName, roleID, roleName string) (*RoleRequest, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO role_requests (guild_id, user_id, user_name, role_id, role_name)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING ` + roleRequestColumns
+	req, err := scanRoleRequest(db.q.QueryRowContext(ctx, query, guildID, userID, userName, roleID, roleName))
+	if err != nil {
+		return nil, mapConstraintError(err)
+	}
+	return req, nil
+}
+
+// GetRoleRequestForUpdate читает заявку и блокирует её до конца транзакции,
+// чтобы два модератора не рассмотрели её одновременно
+func (db *DB) GetRoleRequestForUpdate(ctx context.Context, id int) (*RoleRequest, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + roleRequestColumns + ` FROM role_requests WHERE id = $1 FOR UPDATE`
+	req, err := scanRoleRequest(db.q.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("role request %d not found", id)
+		}
+		return nil, err
+	}
+	return req, nil
+}
+
+// GetUserRoleRequests возвращает все заявки пользователя на сервере, от новых к старым
+func (db *DB) GetUserRoleRequests(ctx context.Context, guildID, userID string) ([]RoleRequest, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + roleRequestColumns + `
+              FROM role_requests WHERE guild_id = $1 AND user_id = $2
+              ORDER BY created_at DESC, id DESC`
+	rows, err := db.q.QueryContext(ctx, query, guildID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []RoleRequest
+	for rows.Next() {
+		req, err := scanRoleRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+	return requests, rows.Err()
+}
+
+// SetRoleRequestMessage сохраняет ID карточки заявки в канале модераторов
+func (db *DB) SetRoleRequestMessage(ctx context.Context, id int, messageID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.q.ExecContext(ctx, `UPDATE role_requests SET message_id = $1 WHERE id = $2`, messageID, id)
+	return err
+}
+
+// ReopenRoleRequest возвращает одобренную заявку на рассмотрение, если роль по ней
+// выдать не удалось
+func (db *DB) ReopenRoleRequest(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE role_requests
+              SET status = 'pending', decided_by = '', decided_at = NULL
+              WHERE id = $1 AND status = 'approved'`
+	_, err := db.q.ExecContext(ctx, query, id)
+	return err
+}
+
+// DecideRoleRequest записывает решение модератора по заявке
+func (db *DB) DecideRoleRequest(ctx context.Context, id int, status, moderatorID, reason string) error {
+	if status != "approved" && status != "denied" {
+		return fmt.Errorf("invalid request status: %s", status)
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE role_requests
+              SET status = $1, decided_by = $2, deny_reason = $3, decided_at = NOW()
+              WHERE id = $4 AND status = 'pending'`
+	result, err := db.q.ExecContext(ctx, query, status, moderatorID, reason, id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("role request %d is not pending", id)
+	}
+	return nil
+}
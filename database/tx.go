@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"neble_2/logging"
+)
+
+// txState хранит отложенные действия транзакции: статистику обновляем и об освободившихся
+// местах сообщаем только после коммита
+type txState struct {
+	statsChanged bool
+	freedRoles   []string
+}
+
+// WithTx выполняет fn в транзакции. Все методы хранилища, вызванные у переданного
+// txDB, работают внутри неё. Ошибка или паника в fn откатывает транзакцию.
+// Вложенный вызов WithTx переиспользует уже открытую транзакцию.
+func (db *DB) WithTx(ctx context.Context, fn func(txDB *DB) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	state := &txState{}
+	txDB := &DB{DB: db.DB, q: instrumentedQueryer{q: tx}, statsUpdater: db.statsUpdater, roleFreed: db.roleFreed, queryTimeout: db.queryTimeout, tx: state}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(txDB); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			slog.ErrorContext(ctx, "Error rolling back transaction", logging.Err(rbErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	if state.statsChanged {
+		db.notifyStats()
+	}
+	for _, roleID := range state.freedRoles {
+		db.notifyRoleFreed(ctx, roleID)
+	}
+	return nil
+}
+
+// notifyStats сообщает об изменении ролей; внутри транзакции - откладывает до коммита
+func (db *DB) notifyStats() {
+	if db.tx != nil {
+		db.tx.statsChanged = true
+		return
+	}
+	if db.statsUpdater != nil {
+		go db.statsUpdater()
+	}
+}
+
+// notifyRoleFreed сообщает об освободившемся месте в роли; внутри транзакции - откладывает до коммита
+func (db *DB) notifyRoleFreed(ctx context.Context, roleID string) {
+	if db.tx != nil {
+		db.tx.freedRoles = append(db.tx.freedRoles, roleID)
+		return
+	}
+	if db.roleFreed != nil {
+		go db.roleFreed(context.WithoutCancel(ctx), roleID)
+	}
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OpenVoiceSession начинает голосовую сессию пользователя на сервере, если она ещё не открыта.
+// Сессия относится к роли, активной у пользователя в момент входа (пустая - без роли);
+// при смене роли её продолжает новая сессия (switchVoiceSessionRole).
+func (db *DB) OpenVoiceSession(ctx context.Context, guildID, userID, userName, roleID, roleName string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO voice_sessions (guild_id, user_id, user_name, role_id, role_name, started_at)
+              VALUES ($1, $2, $3, $4, $5, NOW())
+              ON CONFLICT (guild_id, user_id) WHERE ended_at IS NULL DO NOTHING`
+	_, err := db.q.ExecContext(ctx, query, guildID, userID, userName, roleID, roleName)
+	return err
+}
+
+// CloseVoiceSession завершает открытую голосовую сессию пользователя на сервере
+func (db *DB) CloseVoiceSession(ctx context.Context, guildID, userID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE voice_sessions SET ended_at = NOW()
+              WHERE guild_id = $1 AND user_id = $2 AND ended_at IS NULL`
+	_, err := db.q.ExecContext(ctx, query, guildID, userID)
+	return err
+}
+
+// switchVoiceSessionRole переносит открытую сессию пользователя на роль roleID (пустая -
+// без роли), когда активная роль меняется: сессия закрывается и сразу продолжается
+// новой, так что время в голосе делится между ролями по моменту смены
+func (db *DB) switchVoiceSessionRole(ctx context.Context, guildID, userID, roleID, roleName string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE voice_sessions SET ended_at = NOW()
+              WHERE guild_id = $1 AND user_id = $2 AND ended_at IS NULL AND role_id <> $3
+              RETURNING user_name`
+	var userName string
+	err := db.q.QueryRowContext(ctx, query, guildID, userID, roleID).Scan(&userName)
+	if err == sql.ErrNoRows {
+		return nil // пользователь не в голосе или сессия уже с этой ролью
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO voice_sessions (guild_id, user_id, user_name, role_id, role_name, started_at)
+             VALUES ($1, $2, $3, $4, $5, NOW())
+             ON CONFLICT (guild_id, user_id) WHERE ended_at IS NULL DO NOTHING`
+	_, err = db.q.ExecContext(ctx, query, guildID, userID, userName, roleID, roleName)
+	return err
+}
+
+// DeleteVoiceSessionsBefore удаляет сессии, закончившиеся раньше before
+func (db *DB) DeleteVoiceSessionsBefore(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	result, err := db.q.ExecContext(ctx, `DELETE FROM voice_sessions WHERE ended_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CloseVoiceSessionsExcept завершает открытые сессии сервера всех, кого нет среди userIDs.
+// Нужна после переподключения: выходы из голоса за время простоя бот не видел.
+func (db *DB) CloseVoiceSessionsExcept(ctx context.Context, guildID string, userIDs []string) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE voice_sessions SET ended_at = NOW()
+              WHERE guild_id = $1 AND ended_at IS NULL AND NOT (user_id = ANY($2))`
+	result, err := db.q.ExecContext(ctx, query, guildID, pq.Array(userIDs))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// voiceSecondsSince - длительность сессий в секундах начиная с since ($1); открытые сессии считаются до текущего момента
+const voiceSecondsSince = `SUM(EXTRACT(EPOCH FROM COALESCE(ended_at, NOW()) - GREATEST(started_at, $1)))`
+
+// GetVoiceTotalsByRole суммирует время в голосе на сервере по ролям начиная с since
+func (db *DB) GetVoiceTotalsByRole(ctx context.Context, guildID string, since time.Time) ([]VoiceTotal, error) {
+	query := `SELECT role_id, role_name, ` + voiceSecondsSince + `
+              FROM voice_sessions
+              WHERE guild_id = $2 AND role_id <> '' AND COALESCE(ended_at, NOW()) > $1
+              GROUP BY role_id, role_name
+              ORDER BY 3 DESC`
+	return db.queryVoiceTotals(ctx, query, since, guildID)
+}
+
+// GetVoiceTotalsByUser суммирует время в голосе на сервере по пользователям начиная с since, не больше limit записей
+func (db *DB) GetVoiceTotalsByUser(ctx context.Context, guildID string, since time.Time, limit int) ([]VoiceTotal, error) {
+	query := `SELECT user_id, MAX(user_name), ` + voiceSecondsSince + `
+              FROM voice_sessions
+              WHERE guild_id = $2 AND COALESCE(ended_at, NOW()) > $1
+              GROUP BY user_id
+              ORDER BY 3 DESC
+              LIMIT $3`
+	return db.queryVoiceTotals(ctx, query, since, guildID, limit)
+}
+
+// GetUserVoiceTotal возвращает время пользователя в голосе на сервере начиная с since
+func (db *DB) GetUserVoiceTotal(ctx context.Context, guildID, userID string, since time.Time) (time.Duration, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT COALESCE(` + voiceSecondsSince + `, 0)
+              FROM voice_sessions
+              WHERE guild_id = $2 AND user_id = $3 AND COALESCE(ended_at, NOW()) > $1`
+	var seconds float64
+	err := db.q.QueryRowContext(ctx, query, since, guildID, userID).Scan(&seconds)
+	return time.Duration(seconds * float64(time.Second)), err
+}
+
+func (db *DB) queryVoiceTotals(ctx context.Context, query string, args ...any) ([]VoiceTotal, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []VoiceTotal
+	for rows.Next() {
+		var (
+			total   VoiceTotal
+			seconds float64
+		)
+		if err := rows.Scan(&total.ID, &total.Name, &seconds); err != nil {
+			return nil, err
+		}
+		total.Duration = time.Duration(seconds * float64(time.Second))
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
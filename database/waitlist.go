@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LockRoleCapacity сериализует выдачу роли с ограниченным числом мест до конца транзакции
+func (db *DB) LockRoleCapacity(ctx context.Context, roleID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.q.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('role_capacity:' || $1))`, roleID)
+	return err
+}
+
+// CountActiveHolders возвращает число пользователей с активной ролью
+func (db *DB) CountActiveHolders(ctx context.Context, roleID string) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := db.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_roles WHERE role_id = $1 AND is_active = true`, roleID).Scan(&count)
+	return count, err
+}
+
+// CountReservations возвращает число мест, придержанных для уведомлённых из очереди
+// (кроме места самого пользователя exceptUserID)
+func (db *DB) CountReservations(ctx context.Context, roleID, exceptUserID string, window time.Duration) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT COUNT(*) FROM role_waitlist
+              WHERE role_id = $1 AND user_id <> $2 AND notified_at > $3`
+	var count int
+	err := db.q.QueryRowContext(ctx, query, roleID, exceptUserID, time.Now().Add(-window)).Scan(&count)
+	return count, err
+}
+
+// JoinWaitlist ставит пользователя в очередь на роль и возвращает его место в ней.
+// Повторный вызов не меняет место.
+func (db *DB) JoinWaitlist(ctx context.Context, userID, userName, roleID string) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO role_waitlist (user_id, user_name, role_id)
+              VALUES ($1, $2, $3)
+              ON CONFLICT (user_id, role_id) DO NOTHING`
+	if _, err := db.q.ExecContext(ctx, query, userID, userName, roleID); err != nil {
+		return 0, err
+	}
+
+	query = `SELECT position FROM (
+                 SELECT user_id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS position
+                 FROM role_waitlist WHERE role_id = $1
+             ) q WHERE user_id = $2`
+	var position int
+	err := db.q.QueryRowContext(ctx, query, roleID, userID).Scan(&position)
+	return position, err
+}
+
+// LeaveWaitlist убирает пользователя из очереди на роль
+func (db *DB) LeaveWaitlist(ctx context.Context, userID, roleID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.q.ExecContext(ctx, `DELETE FROM role_waitlist WHERE user_id = $1 AND role_id = $2`, userID, roleID)
+	return err
+}
+
+// GetWaitlistPositions возвращает все очереди пользователя с его местом в каждой
+func (db *DB) GetWaitlistPositions(ctx context.Context, userID string) ([]WaitlistEntry, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, user_name, role_id, created_at, notified_at, position FROM (
+                  SELECT *, ROW_NUMBER() OVER (PARTITION BY role_id ORDER BY created_at, id) AS position
+                  FROM role_waitlist
+              ) q WHERE user_id = $1
+              ORDER BY created_at`
+	rows, err := db.q.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []WaitlistEntry
+	for rows.Next() {
+		var e WaitlistEntry
+		if err := rows.Scan(&e.ID, &e.UserID, &e.UserName, &e.RoleID, &e.CreatedAt, &e.NotifiedAt, &e.Position); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// NextWaitlistEntry возвращает первого в очереди, кому ещё не сообщали о свободном месте
+func (db *DB) NextWaitlistEntry(ctx context.Context, roleID string) (*WaitlistEntry, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT id, user_id, user_name, role_id, created_at, notified_at
+              FROM role_waitlist
+              WHERE role_id = $1 AND notified_at IS NULL
+              ORDER BY created_at, id
+              LIMIT 1
+              FOR UPDATE SKIP LOCKED`
+	var e WaitlistEntry
+	err := db.q.QueryRowContext(ctx, query, roleID).Scan(&e.ID, &e.UserID, &e.UserName, &e.RoleID, &e.CreatedAt, &e.NotifiedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+// MarkWaitlistNotified придерживает место за пользователем из очереди
+func (db *DB) MarkWaitlistNotified(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.q.ExecContext(ctx, `UPDATE role_waitlist SET notified_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+// RemoveWaitlistEntry удаляет запись очереди
+func (db *DB) RemoveWaitlistEntry(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.q.ExecContext(ctx, `DELETE FROM role_waitlist WHERE id = $1`, id)
+	return err
+}
+
+// ExpireWaitlistReservations удаляет из очереди тех, кто не занял придержанное место
+// за window, и сообщает об освободившихся местах
+func (db *DB) ExpireWaitlistReservations(ctx context.Context, window time.Duration) (int, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM role_waitlist WHERE notified_at < $1 RETURNING role_id`
+	rows, err := db.q.QueryContext(ctx, query, time.Now().Add(-window))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var roleIDs []string
+	for rows.Next() {
+		var roleID string
+		if err := rows.Scan(&roleID); err != nil {
+			return 0, err
+		}
+		roleIDs = append(roleIDs, roleID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, roleID := range roleIDs {
+		db.notifyRoleFreed(ctx, roleID)
+	}
+	return len(roleIDs), nil
+}
+
+// ResumeWaitlists заново запускает раздачу мест по ролям, в очереди на которые
+// остались пользователи без придержанного места: раздачу могла прервать временная
+// ошибка Discord. Если свободных мест нет, раздача сразу заканчивается.
+func (db *DB) ResumeWaitlists(ctx context.Context) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.q.QueryContext(ctx, `SELECT DISTINCT role_id FROM role_waitlist WHERE notified_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var roleIDs []string
+	for rows.Next() {
+		var roleID string
+		if err := rows.Scan(&roleID); err != nil {
+			return err
+		}
+		roleIDs = append(roleIDs, roleID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, roleID := range roleIDs {
+		db.notifyRoleFreed(ctx, roleID)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const webhookDeliveryColumns = `id, webhook, event_id, event, payload, status, attempts, next_attempt_at, last_error, created_at, delivered_at`
+
+// EnqueueWebhookDeliveries ставит событие в очередь доставки каждому из вебхуков webhooks.
+// payload передаётся строкой: []byte lib/pq отправил бы как bytea
+func (db *DB) EnqueueWebhookDeliveries(ctx context.Context, webhooks []string, eventID, event string, payload []byte) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO webhook_deliveries (webhook, event_id, event, payload)
+              SELECT unnest($1::text[]), $2, $3, $4`
+	_, err := db.q.ExecContext(ctx, query, pq.Array(webhooks), eventID, event, string(payload))
+	return err
+}
+
+// GetWebhookQueueHeads возвращает самое старое недоставленное событие каждого вебхука,
+// в том числе если его следующая попытка ещё не наступила: события одному вебхуку
+// доставляются строго по порядку
+func (db *DB) GetWebhookQueueHeads(ctx context.Context) ([]WebhookDelivery, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT DISTINCT ON (webhook) ` + webhookDeliveryColumns + `
+              FROM webhook_deliveries
+              WHERE status = 'pending'
+              ORDER BY webhook, id`
+	rows, err := db.q.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(&d.ID, &d.Webhook, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// MarkWebhookDelivered отмечает успешную доставку
+func (db *DB) MarkWebhookDelivered(ctx context.Context, id int) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries
+              SET status = 'delivered', attempts = attempts + 1, last_error = '', delivered_at = NOW()
+              WHERE id = $1`
+	_, err := db.q.ExecContext(ctx, query, id)
+	return err
+}
+
+// RetryWebhookDelivery записывает неудачную попытку и откладывает следующую до next
+func (db *DB) RetryWebhookDelivery(ctx context.Context, id int, next time.Time, lastError string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries
+              SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+              WHERE id = $1`
+	_, err := db.q.ExecContext(ctx, query, id, next, lastError)
+	return err
+}
+
+// FailWebhookDelivery прекращает попытки доставить событие
+func (db *DB) FailWebhookDelivery(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries
+              SET status = 'failed', attempts = attempts + 1, last_error = $2
+              WHERE id = $1`
+	_, err := db.q.ExecContext(ctx, query, id, lastError)
+	return err
+}
+
+// DeleteFinishedWebhookDeliveries удаляет доставленные и брошенные события старше before
+func (db *DB) DeleteFinishedWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < $1`
+	result, err := db.q.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,88 @@
+// Package eligibility проверяет условия ролей из каталога: при выборе роли, её
+// продлении, раздаче мест из очереди и автопродлении.
+package eligibility
+
+import (
+	"fmt"
+	"neble_2/config"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Error - нарушенное условие роли; текст объясняет его пользователю
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Check проверяет условия роли из каталога и возвращает Error с объяснением
+// первого нарушенного правила. renewals - сколько раз роль уже продлевали
+// подряд (0 при выборе роли).
+func Check(member *discordgo.Member, role config.RoleDefinition, renewals int, now time.Time) error {
+	rules := role.Eligibility
+
+	if len(rules.RequiredRoles) > 0 && !hasAnyRole(member, rules.RequiredRoles) {
+		return Error(fmt.Sprintf("Роль **%s** доступна только участникам с ролью %s.",
+			role.Name, mentionRoles(rules.RequiredRoles)))
+	}
+
+	for _, forbidden := range rules.ForbiddenRoles {
+		if slices.Contains(member.Roles, forbidden) {
+			return Error(fmt.Sprintf("Роль **%s** недоступна участникам с ролью <@&%s>.", role.Name, forbidden))
+		}
+	}
+
+	if rules.MinAccountAge.Duration > 0 {
+		created, err := discordgo.SnowflakeTimestamp(member.User.ID)
+		if err == nil && now.Sub(created) < rules.MinAccountAge.Duration {
+			return Error(fmt.Sprintf("Роль **%s** доступна аккаунтам Discord старше %s Попробуйте после %s.",
+				role.Name, formatDuration(rules.MinAccountAge.Duration),
+				created.Add(rules.MinAccountAge.Duration).Format("02.01.2006 15:04")))
+		}
+	}
+
+	if rules.MinGuildAge.Duration > 0 && !member.JoinedAt.IsZero() && now.Sub(member.JoinedAt) < rules.MinGuildAge.Duration {
+		return Error(fmt.Sprintf("Роль **%s** доступна тем, кто на сервере дольше %s Попробуйте после %s.",
+			role.Name, formatDuration(rules.MinGuildAge.Duration),
+			member.JoinedAt.Add(rules.MinGuildAge.Duration).Format("02.01.2006 15:04")))
+	}
+
+	if rules.MaxRenewals > 0 && renewals >= rules.MaxRenewals {
+		return Error(fmt.Sprintf("Роль **%s** можно продлить не больше %d раз подряд. Выберите роль заново на панели.",
+			role.Name, rules.MaxRenewals))
+	}
+
+	return nil
+}
+
+func hasAnyRole(member *discordgo.Member, roleIDs []string) bool {
+	for _, id := range roleIDs {
+		if slices.Contains(member.Roles, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func mentionRoles(roleIDs []string) string {
+	mentions := make([]string, len(roleIDs))
+	for idx, id := range roleIDs {
+		mentions[idx] = "<@&" + id + ">"
+	}
+	return strings.Join(mentions, " или ")
+}
+
+// formatDuration печатает длительность в днях или часах
+func formatDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d дн.", int(d/(24*time.Hour)))
+	}
+	if d >= time.Hour {
+		return fmt.Sprintf("%d ч.", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%d мин.", int(d/time.Minute))
+}
@@ -0,0 +1,154 @@
+package eligibility
+
+import (
+	"errors"
+	"neble_2/config"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// discordEpoch - начало отсчёта snowflake Discord в миллисекундах
+const discordEpoch = 1420070400000
+
+// snowflakeAt возвращает ID аккаунта, созданного в момент created
+func snowflakeAt(created time.Time) string {
+	return strconv.FormatInt((created.UnixMilli()-discordEpoch)<<22, 10)
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	member := func(roles []string, accountAge, guildAge time.Duration) *discordgo.Member {
+		m := &discordgo.Member{
+			User:  &discordgo.User{ID: snowflakeAt(now.Add(-accountAge))},
+			Roles: roles,
+		}
+		if guildAge > 0 {
+			m.JoinedAt = now.Add(-guildAge)
+		}
+		return m
+	}
+	role := func(rules config.Eligibility) config.RoleDefinition {
+		return config.RoleDefinition{ID: "100", Name: "Патруль", Eligibility: rules}
+	}
+
+	tests := []struct {
+		name     string
+		member   *discordgo.Member
+		rules    config.Eligibility
+		renewals int
+		want     string // фрагмент ошибки; пусто - условия выполнены
+	}{
+		{
+			name:   "no rules",
+			member: member(nil, day, day),
+		},
+		{
+			name:   "has one of required roles",
+			member: member([]string{"2"}, day, day),
+			rules:  config.Eligibility{RequiredRoles: []string{"1", "2"}},
+		},
+		{
+			name:   "missing required roles",
+			member: member([]string{"3"}, day, day),
+			rules:  config.Eligibility{RequiredRoles: []string{"1", "2"}},
+			want:   "только участникам с ролью <@&1> или <@&2>",
+		},
+		{
+			name:   "has forbidden role",
+			member: member([]string{"1", "9"}, day, day),
+			rules:  config.Eligibility{ForbiddenRoles: []string{"9"}},
+			want:   "недоступна участникам с ролью <@&9>",
+		},
+		{
+			name:   "forbidden role checked after required",
+			member: member([]string{"9"}, day, day),
+			rules:  config.Eligibility{RequiredRoles: []string{"1"}, ForbiddenRoles: []string{"9"}},
+			want:   "только участникам с ролью <@&1>",
+		},
+		{
+			name:   "account old enough",
+			member: member(nil, 31*day, day),
+			rules:  config.Eligibility{MinAccountAge: config.Duration{Duration: 30 * day}},
+		},
+		{
+			name:   "account too young",
+			member: member(nil, 10*day, day),
+			rules:  config.Eligibility{MinAccountAge: config.Duration{Duration: 30 * day}},
+			want:   "аккаунтам Discord старше 30 дн. Попробуйте после 21.06.2024 12:00",
+		},
+		{
+			name:   "in guild long enough",
+			member: member(nil, 365*day, 3*day),
+			rules:  config.Eligibility{MinGuildAge: config.Duration{Duration: 48 * time.Hour}},
+		},
+		{
+			name:   "joined too recently",
+			member: member(nil, 365*day, 36*time.Hour),
+			rules:  config.Eligibility{MinGuildAge: config.Duration{Duration: 48 * time.Hour}},
+			want:   "на сервере дольше 2 дн. Попробуйте после 02.06.2024 00:00",
+		},
+		{
+			name:   "join date unknown",
+			member: member(nil, 365*day, 0),
+			rules:  config.Eligibility{MinGuildAge: config.Duration{Duration: 48 * time.Hour}},
+		},
+		{
+			name:     "renewals below limit",
+			member:   member(nil, day, day),
+			rules:    config.Eligibility{MaxRenewals: 3},
+			renewals: 2,
+		},
+		{
+			name:     "renewal limit reached",
+			member:   member(nil, day, day),
+			rules:    config.Eligibility{MaxRenewals: 3},
+			renewals: 3,
+			want:     "не больше 3 раз подряд",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.member, role(tt.rules), tt.renewals, now)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Check = %v, want nil", err)
+				}
+				return
+			}
+
+			var ee Error
+			if !errors.As(err, &ee) {
+				t.Fatalf("Check = %v, want an eligibility.Error", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Check = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{7 * 24 * time.Hour, "7 дн."},
+		{36 * time.Hour, "36 ч."},
+		{2 * time.Hour, "2 ч."},
+		{90 * time.Minute, "1 ч."},
+		{45 * time.Minute, "45 мин."},
+	}
+
+	for _, tt := range tests {
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
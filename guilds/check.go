@@ -0,0 +1,151 @@
+package guilds
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/logging"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Check проверяет через API Discord, что бот может работать на каждом сервере:
+// сервер, каналы и роли существуют, у бота есть право управлять ролями и его роль
+// выше выдаваемых. Ошибки сервера из GUILD_ID останавливают запуск - это основная
+// конфигурация; о проблемах остальных серверов бот только предупреждает, чтобы
+// один неверно настроенный сервер не выключал всех.
+func (r *Registry) Check(ctx context.Context, s *discordgo.Session) error {
+	bot, err := s.User("@me", discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("get bot user: %w", err)
+	}
+
+	baseGuildID := r.Base().GuildID
+	var fatal []string
+	for _, cfg := range r.All() {
+		problems := checkGuild(ctx, s, bot.ID, cfg)
+		if len(problems) == 0 {
+			slog.InfoContext(ctx, "Startup check passed", logging.GuildID(cfg.GuildID))
+			continue
+		}
+
+		if cfg.GuildID == baseGuildID {
+			fatal = append(fatal, problems...)
+			continue
+		}
+		slog.WarnContext(ctx, "Startup check found problems", logging.GuildID(cfg.GuildID), "problems", problems)
+	}
+
+	if len(fatal) > 0 {
+		return fmt.Errorf("startup check failed for guild %s:\n  - %s", baseGuildID, strings.Join(fatal, "\n  - "))
+	}
+	return nil
+}
+
+// checkGuild возвращает список проблем конфигурации одного сервера
+func checkGuild(ctx context.Context, s *discordgo.Session, botID string, cfg *config.Config) []string {
+	guild, err := s.Guild(cfg.GuildID, discordgo.WithContext(ctx))
+	if err != nil {
+		return []string{fmt.Sprintf("guild %s is not accessible (is the bot invited?): %v", cfg.GuildID, err)}
+	}
+	roles, err := s.GuildRoles(cfg.GuildID, discordgo.WithContext(ctx))
+	if err != nil {
+		return []string{fmt.Sprintf("cannot list roles: %v", err)}
+	}
+	member, err := s.GuildMember(cfg.GuildID, botID, discordgo.WithContext(ctx))
+	if err != nil {
+		return []string{fmt.Sprintf("cannot get the bot member: %v", err)}
+	}
+
+	byID := make(map[string]*discordgo.Role, len(roles))
+	for _, role := range roles {
+		byID[role.ID] = role
+	}
+
+	var problems []string
+
+	// Права бота на сервере - объединение прав @everyone (ID совпадает с ID сервера) и его ролей
+	var permissions int64
+	if everyone := byID[cfg.GuildID]; everyone != nil {
+		permissions = everyone.Permissions
+	}
+	top := 0
+	for _, roleID := range member.Roles {
+		if role := byID[roleID]; role != nil {
+			permissions |= role.Permissions
+			top = max(top, role.Position)
+		}
+	}
+	admin := guild.OwnerID == botID || permissions&discordgo.PermissionAdministrator != 0
+	if !admin && permissions&discordgo.PermissionManageRoles == 0 {
+		problems = append(problems, "the bot lacks the Manage Roles permission")
+	}
+
+	// Роли, которые бот выдаёт и снимает, должны быть ниже его высшей роли
+	type assignable struct{ name, id string }
+	managed := make([]assignable, 0, len(cfg.Roles)+1)
+	for _, role := range cfg.Roles {
+		managed = append(managed, assignable{fmt.Sprintf("catalog role %q", role.Name), role.ID})
+	}
+	if cfg.InactiveRoleID != "" {
+		managed = append(managed, assignable{"inactive marker role", cfg.InactiveRoleID})
+	}
+	for _, m := range managed {
+		role := byID[m.id]
+		switch {
+		case role == nil:
+			problems = append(problems, fmt.Sprintf("%s (%s) does not exist", m.name, m.id))
+		case role.Managed:
+			problems = append(problems, fmt.Sprintf("%s %q is managed by an integration and cannot be assigned", m.name, role.Name))
+		case !admin && role.Position >= top:
+			problems = append(problems, fmt.Sprintf("%s %q is not below the bot's highest role, move the bot's role higher", m.name, role.Name))
+		}
+	}
+
+	// Роли из условий получения только проверяются на существование
+	for _, role := range cfg.Roles {
+		for _, roleID := range slices.Concat(role.Eligibility.RequiredRoles, role.Eligibility.ForbiddenRoles) {
+			if byID[roleID] == nil {
+				problems = append(problems, fmt.Sprintf("eligibility role %s of catalog role %q does not exist", roleID, role.Name))
+			}
+		}
+	}
+
+	channels := []struct {
+		name string
+		id   string
+	}{
+		{"role channel", cfg.RoleChannelID},
+		{"notification channel", cfg.NotificationChannelID},
+		{"stats channel", cfg.StatsChannelID},
+		{"staff channel", cfg.StaffChannelID},
+	}
+	for _, c := range channels {
+		if c.id == "" {
+			continue
+		}
+		channel, err := s.Channel(c.id, discordgo.WithContext(ctx))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s is not accessible: %v", c.name, c.id, err))
+			continue
+		}
+		if channel.GuildID != cfg.GuildID {
+			problems = append(problems, fmt.Sprintf("%s %s belongs to another guild", c.name, c.id))
+			continue
+		}
+		perms, err := s.UserChannelPermissions(botID, c.id)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot check permissions in %s %s: %v", c.name, c.id, err))
+			continue
+		}
+		const needed = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages
+		if perms&needed != needed {
+			problems = append(problems, fmt.Sprintf("the bot cannot view or send messages in %s #%s", c.name, channel.Name))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,259 @@
+package guilds
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/database"
+	"neble_2/logging"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registry хранит конфигурацию каждого сервера, к которому подключён бот:
+// значения по умолчанию из окружения, поверх которых применены настройки сервера из БД
+type Registry struct {
+	db *database.DB
+
+	// updateMu упорядочивает Update: изменение читает настройки, которые записал предыдущий
+	updateMu sync.Mutex
+
+	mu       sync.RWMutex
+	base     *config.Config
+	guilds   map[string]*config.Config
+	settings map[string]database.GuildSettings // как они записаны в БД, без значений по умолчанию
+	onReload []func(base *config.Config)
+}
+
+// Change - конфигурация сервера до и после замены
+type Change struct {
+	Old, New *config.Config
+}
+
+func NewRegistry(base *config.Config, db *database.DB) *Registry {
+	return &Registry{
+		db:       db,
+		base:     base,
+		guilds:   make(map[string]*config.Config),
+		settings: make(map[string]database.GuildSettings),
+	}
+}
+
+// Base возвращает конфигурацию из окружения - общие для всех серверов параметры
+// (расписания, таймауты, лимиты) берутся отсюда
+func (r *Registry) Base() *config.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.base
+}
+
+// OnReload регистрирует обработчик, которому передаётся новая конфигурация
+// из окружения после SetBase. Так обновляют своё состояние компоненты,
+// прочитавшие общие параметры при запуске.
+func (r *Registry) OnReload(fn func(base *config.Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onReload = append(r.onReload, fn)
+}
+
+// SetBase атомарно заменяет конфигурацию из окружения и пересобирает конфигурации
+// всех серверов. Обработчики, взявшие конфигурацию до замены, доработают со старой,
+// следующие получат новую. Возвращает изменения по серверам.
+func (r *Registry) SetBase(base *config.Config) []Change {
+	r.mu.Lock()
+	r.base = base
+	changes := make([]Change, 0, len(r.guilds))
+	for guildID, old := range r.guilds {
+		updated := r.build(r.settings[guildID])
+		r.guilds[guildID] = updated
+		changes = append(changes, Change{Old: old, New: updated})
+	}
+	hooks := slices.Clone(r.onReload)
+	r.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(base)
+	}
+	return changes
+}
+
+// Load читает настройки всех серверов. Сервер из GUILD_ID регистрируется
+// автоматически и получает записи, созданные до поддержки нескольких серверов.
+func (r *Registry) Load(ctx context.Context) error {
+	base := r.Base()
+	if base.GuildID != "" {
+		if _, err := r.db.CreateGuildSettings(ctx, base.GuildID); err != nil {
+			return fmt.Errorf("register guild %s: %w", base.GuildID, err)
+		}
+		if err := r.db.AdoptLegacyRows(ctx, base.GuildID); err != nil {
+			return fmt.Errorf("adopt legacy rows: %w", err)
+		}
+	}
+
+	settings, err := r.db.GetGuildSettings(ctx)
+	if err != nil {
+		return fmt.Errorf("load guild settings: %w", err)
+	}
+
+	r.mu.Lock()
+	guilds := make(map[string]*config.Config, len(settings))
+	stored := make(map[string]database.GuildSettings, len(settings))
+	for _, gs := range settings {
+		guilds[gs.GuildID] = r.build(gs)
+		stored[gs.GuildID] = gs
+	}
+	r.guilds = guilds
+	r.settings = stored
+	r.mu.Unlock()
+
+	slog.InfoContext(ctx, "Loaded guild configuration", "guilds", len(guilds))
+	return nil
+}
+
+// Onboard регистрирует сервер, к которому только что подключился бот.
+// Возвращает true, если сервер новый.
+func (r *Registry) Onboard(ctx context.Context, guildID string) (*config.Config, bool, error) {
+	if cfg, ok := r.Get(guildID); ok {
+		return cfg, false, nil
+	}
+
+	created, err := r.db.CreateGuildSettings(ctx, guildID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	gs := database.GuildSettings{GuildID: guildID}
+	r.mu.Lock()
+	cfg := r.build(gs)
+	r.guilds[guildID] = cfg
+	r.settings[guildID] = gs
+	r.mu.Unlock()
+	return cfg, created, nil
+}
+
+// Settings возвращает настройки сервера в том виде, в каком они хранятся в БД
+func (r *Registry) Settings(guildID string) (database.GuildSettings, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	gs, ok := r.settings[guildID]
+	return gs, ok
+}
+
+// Update меняет настройки сервера функцией change, сохраняет их и сразу применяет.
+// Параллельные изменения выполняются по очереди, поэтому ни одно не теряется.
+// Возвращает прежнюю и новую конфигурацию, чтобы вызывающий мог перенести панель и сообщения.
+func (r *Registry) Update(ctx context.Context, guildID string, change func(gs *database.GuildSettings)) (old, updated *config.Config, err error) {
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+
+	gs, ok := r.Settings(guildID)
+	if !ok {
+		return nil, nil, fmt.Errorf("guild %s is not registered", guildID)
+	}
+	change(&gs)
+
+	if err := r.db.UpdateGuildSettings(ctx, gs); err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	updated = r.build(gs)
+	old = r.guilds[gs.GuildID]
+	r.guilds[gs.GuildID] = updated
+	r.settings[gs.GuildID] = gs
+	r.mu.Unlock()
+
+	slog.InfoContext(ctx, "Updated guild settings", logging.GuildID(gs.GuildID))
+	return old, updated, nil
+}
+
+// Get возвращает конфигурацию сервера
+func (r *Registry) Get(guildID string) (*config.Config, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cfg, ok := r.guilds[guildID]
+	return cfg, ok
+}
+
+// All возвращает конфигурации всех серверов в стабильном порядке
+func (r *Registry) All() []*config.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	all := make([]*config.Config, 0, len(r.guilds))
+	for _, cfg := range r.guilds {
+		all = append(all, cfg)
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].GuildID < all[b].GuildID })
+	return all
+}
+
+// ByRoleID находит сервер, в каталоге которого есть роль с указанным ID
+func (r *Registry) ByRoleID(roleID string) (*config.Config, config.RoleDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, cfg := range r.guilds {
+		if role, ok := cfg.RoleByID(roleID); ok {
+			return cfg, role, true
+		}
+	}
+	return nil, config.RoleDefinition{}, false
+}
+
+// build применяет настройки сервера к значениям по умолчанию. Ошибочные
+// значения пропускаются с предупреждением, чтобы одна опечатка не выключала сервер.
+// Вызывается под r.mu.
+func (r *Registry) build(gs database.GuildSettings) *config.Config {
+	cfg := r.base.ForGuild(gs.GuildID)
+
+	overrides := []struct {
+		value  string
+		target *string
+	}{
+		{gs.RoleChannelID, &cfg.RoleChannelID},
+		{gs.NotificationChannelID, &cfg.NotificationChannelID},
+		{gs.StatsChannelID, &cfg.StatsChannelID},
+		{gs.StaffChannelID, &cfg.StaffChannelID},
+		{gs.InactiveRoleID, &cfg.InactiveRoleID},
+	}
+	for _, o := range overrides {
+		if o.value != "" {
+			*o.target = o.value
+		}
+	}
+
+	if len(gs.RoleCatalog) > 0 {
+		roles, err := config.ParseRoleCatalog(gs.RoleCatalog, "of guild "+gs.GuildID)
+		if err != nil {
+			slog.Warn("Ignoring invalid guild role catalog", logging.GuildID(gs.GuildID), logging.Err(err))
+		} else {
+			cfg.Roles = roles
+		}
+	}
+
+	durations := []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"role_duration", gs.RoleDuration, &cfg.RoleDuration},
+		{"renewal_duration", gs.RenewalDuration, &cfg.RenewalDuration},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := config.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			slog.Warn("Ignoring invalid guild setting", logging.GuildID(gs.GuildID), "setting", d.name, "value", d.value)
+			continue
+		}
+		*d.target = parsed
+	}
+
+	return cfg
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"neble_2/database"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// activityWriteInterval - как часто активность одного пользователя записывается в БД:
+	// для окна автопродления в часы и дни точность до минут не нужна
+	activityWriteInterval = 5 * time.Minute
+	// activityTimeout ограничивает запись активности одного события
+	activityTimeout = 5 * time.Second
+)
+
+// ActivityTracker отмечает активность участников сервера по сообщениям и голосовым каналам
+type ActivityTracker struct {
+	db     *database.DB
+	guilds *guilds.Registry
+
+	mu        sync.Mutex
+	lastWrite map[activityKey]time.Time
+}
+
+// activityKey - участник конкретного сервера: активность считается отдельно на каждом
+type activityKey struct {
+	guildID string
+	userID  string
+}
+
+func NewActivityTracker(db *database.DB, registry *guilds.Registry) *ActivityTracker {
+	return &ActivityTracker{db: db, guilds: registry, lastWrite: make(map[activityKey]time.Time)}
+}
+
+// MessageCreate - обработчик новых сообщений для discordgo
+func (t *ActivityTracker) MessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author == nil || m.Author.Bot {
+		return
+	}
+	if _, ok := t.guilds.Get(m.GuildID); !ok {
+		return
+	}
+	t.touch(activityKey{guildID: m.GuildID, userID: m.Author.ID})
+}
+
+// VoiceStateUpdate - обработчик изменений голосового состояния: активностью считается вход в канал
+func (t *ActivityTracker) VoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if v.ChannelID == "" {
+		return
+	}
+	if _, ok := t.guilds.Get(v.GuildID); !ok {
+		return
+	}
+	if v.Member != nil && v.Member.User != nil && v.Member.User.Bot {
+		return
+	}
+	t.touch(activityKey{guildID: v.GuildID, userID: v.UserID})
+}
+
+func (t *ActivityTracker) touch(key activityKey) {
+	now := time.Now()
+
+	t.mu.Lock()
+	if now.Sub(t.lastWrite[key]) < activityWriteInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastWrite[key] = now
+	t.prune(now)
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), activityTimeout)
+	defer cancel()
+
+	if err := t.db.TouchActivity(ctx, key.guildID, key.userID, now); err != nil {
+		slog.ErrorContext(ctx, "Error recording activity", logging.GuildID(key.guildID), logging.UserID(key.userID), logging.Err(err))
+	}
+}
+
+// prune забывает давние записи, чтобы карта не росла бесконечно
+func (t *ActivityTracker) prune(now time.Time) {
+	if len(t.lastWrite) < 4096 {
+		return
+	}
+	for key, at := range t.lastWrite {
+		if now.Sub(at) >= activityWriteInterval {
+			delete(t.lastWrite, key)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/customid"
+	"neble_2/database"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// denyReasonInputID - поле причины отказа в модальном окне
+const denyReasonInputID = "reason"
+
+// submitRoleRequest создаёт заявку на роль, требующую одобрения, и публикует её карточку для модераторов
+func submitRoleRequest(ctx context.Context, s *discordgo.Session, r *Responder, db *database.DB, cfg *config.Config, codec *customid.Codec, user *discordgo.User, role config.RoleDefinition) {
+	if cfg.StaffChannelID == "" {
+		slog.WarnContext(ctx, "Role requires approval but the staff channel is not set", "role_id", role.ID)
+		r.Reply("Эта роль выдаётся модераторами, но канал для заявок не настроен. Обратитесь к администрации.")
+		return
+	}
+
+	active, err := db.GetActiveRoleByUserID(ctx, cfg.GuildID, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking existing role", logging.Err(err))
+		r.ReplyFailure("Ошибка при проверке ролей")
+		return
+	}
+	if active != nil {
+		r.Reply(fmt.Sprintf("У вас уже есть активная роль **%s**. Сначала отмените её.", active.RoleName))
+		return
+	}
+
+	req, err := db.CreateRoleRequest(ctx, cfg.GuildID, user.ID, user.Username, role.ID, role.Name)
+	if errors.Is(err, database.ErrPendingRequestExists) {
+		r.Reply("У вас уже есть заявка на рассмотрении. Дождитесь решения модераторов.")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error creating role request", logging.Err(err))
+		r.ReplyFailure("Ошибка при создании заявки")
+		return
+	}
+
+	var msg *discordgo.Message
+	components, err := requestCardComponents(codec, req.ID)
+	if err == nil {
+		msg, err = s.ChannelMessageSendComplex(cfg.StaffChannelID, &discordgo.MessageSend{
+			Content:         fmt.Sprintf("📝 Заявка #%d: <@%s> хочет получить роль **%s**.", req.ID, user.ID, role.Name),
+			Components:      components,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}, discordgo.WithContext(ctx))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error posting role request card", "request_id", req.ID, logging.Err(err))
+		// Заявку без карточки никто не увидит - закрываем её сразу
+		if err := db.DecideRoleRequest(ctx, req.ID, "denied", "", "карточку заявки не удалось опубликовать"); err != nil {
+			slog.ErrorContext(ctx, "Error closing role request", "request_id", req.ID, logging.Err(err))
+		}
+		r.ReplyFailure("Не удалось отправить заявку модераторам, попробуйте позже")
+		return
+	}
+
+	if err := db.SetRoleRequestMessage(ctx, req.ID, msg.ID); err != nil {
+		slog.ErrorContext(ctx, "Error saving role request message", "request_id", req.ID, logging.Err(err))
+	}
+
+	r.Reply(fmt.Sprintf("Заявка на роль **%s** отправлена модераторам. Мы сообщим о решении.", role.Name))
+}
+
+// requestCardComponents строит кнопки решения для карточки заявки
+func requestCardComponents(codec *customid.Codec, requestID int) ([]discordgo.MessageComponent, error) {
+	id := strconv.Itoa(requestID)
+	approveID, err := codec.Encode(customid.ActionApproveRequest, id)
+	if err != nil {
+		return nil, err
+	}
+	denyID, err := codec.Encode(customid.ActionDenyRequest, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Одобрить",
+					Style:    discordgo.SuccessButton,
+					CustomID: approveID,
+				},
+				discordgo.Button{
+					Label:    "Отклонить",
+					Style:    discordgo.DangerButton,
+					CustomID: denyID,
+				},
+			},
+		},
+	}, nil
+}
+
+func handleApproveRequest(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config, id customid.ID) {
+	if !isModerator(i) {
+		r.Reply("Рассматривать заявки могут только модераторы.")
+		return
+	}
+
+	requestID, err := id.IntArg()
+	if err != nil {
+		r.Reply("Ошибка обработки запроса: неверный ID заявки")
+		return
+	}
+	moderator := interactionUser(i)
+
+	var req *database.RoleRequest
+	var granted *database.UserRole
+	err = db.WithTx(ctx, func(tx *database.DB) error {
+		var err error
+		req, err = tx.GetRoleRequestForUpdate(ctx, requestID)
+		if err != nil {
+			return err
+		}
+		if req.Status != "pending" {
+			return userError("Эта заявка уже рассмотрена.")
+		}
+
+		if err := tx.DecideRoleRequest(ctx, req.ID, "approved", moderator.ID, ""); err != nil {
+			return err
+		}
+
+		// Роль могли убрать из каталога, пока заявка ждала: тогда выдаём её без лимита мест
+		role, ok := cfg.RoleByID(req.RoleID)
+		if !ok {
+			role = config.RoleDefinition{ID: req.RoleID, Name: req.RoleName}
+		}
+
+		granted, err = assignRole(ctx, tx, cfg, req.UserID, req.UserName, role)
+		if errors.Is(err, ErrRoleFull) {
+			return userError(fmt.Sprintf("Все места в роли **%s** заняты.", role.Name))
+		}
+		if errors.Is(err, database.ErrActiveRoleExists) {
+			return userError("У пользователя уже есть активная роль.")
+		}
+		return err
+	})
+	if err == nil {
+		// Если Discord не выдал роль, заявка возвращается на рассмотрение
+		err = grantAssignedRole(ctx, s, db, cfg, granted, func(tx *database.DB) error {
+			return tx.ReopenRoleRequest(ctx, req.ID)
+		})
+	}
+	if err != nil {
+		respondError(r, err, "Ошибка при одобрении заявки")
+		return
+	}
+
+	slog.InfoContext(ctx, "Role request approved", "request_id", req.ID, "applicant_id", req.UserID, "role_id", req.RoleID)
+	metrics.RoleEvents.WithLabelValues(req.GuildID, req.RoleID, metrics.EventGranted).Inc()
+	closeRequestCard(ctx, s, cfg, req, fmt.Sprintf("✅ Одобрено модератором <@%s>", moderator.ID))
+	notifyUser(ctx, s, cfg, req.UserID, fmt.Sprintf("Ваша заявка на роль **%s** одобрена, роль выдана!", req.RoleName))
+	r.Reply(fmt.Sprintf("Заявка #%d одобрена.", req.ID))
+}
+
+// handleDenyRequest открывает модальное окно для причины отказа
+func handleDenyRequest(i *discordgo.InteractionCreate, r *Responder, codec *customid.Codec, id customid.ID) {
+	if !isModerator(i) {
+		r.Reply("Рассматривать заявки могут только модераторы.")
+		return
+	}
+
+	modalID, err := codec.Encode(customid.ActionDenyReason, id.Arg)
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии формы")
+		return
+	}
+
+	err = r.Modal(modalID, "Отклонение заявки", []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:    denyReasonInputID,
+					Label:       "Причина отказа",
+					Style:       discordgo.TextInputParagraph,
+					Placeholder: "Пользователь увидит этот текст",
+					Required:    false,
+					MaxLength:   500,
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.ErrorContext(r.ctx, "Error opening deny reason modal", logging.Err(err))
+	}
+}
+
+func handleDenyReason(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config, id customid.ID) {
+	if !isModerator(i) {
+		r.Reply("Рассматривать заявки могут только модераторы.")
+		return
+	}
+
+	requestID, err := id.IntArg()
+	if err != nil {
+		r.Reply("Ошибка обработки запроса: неверный ID заявки")
+		return
+	}
+	moderator := interactionUser(i)
+	reason := modalTextValue(i, denyReasonInputID)
+
+	var req *database.RoleRequest
+	err = db.WithTx(ctx, func(tx *database.DB) error {
+		var err error
+		req, err = tx.GetRoleRequestForUpdate(ctx, requestID)
+		if err != nil {
+			return err
+		}
+		if req.Status != "pending" {
+			return userError("Эта заявка уже рассмотрена.")
+		}
+		return tx.DecideRoleRequest(ctx, req.ID, "denied", moderator.ID, reason)
+	})
+	if err != nil {
+		respondError(r, err, "Ошибка при отклонении заявки")
+		return
+	}
+
+	slog.InfoContext(ctx, "Role request denied", "request_id", req.ID, "applicant_id", req.UserID, "role_id", req.RoleID)
+
+	status := fmt.Sprintf("❌ Отклонено модератором <@%s>", moderator.ID)
+	message := fmt.Sprintf("Ваша заявка на роль **%s** отклонена.", req.RoleName)
+	if reason != "" {
+		status += "\nПричина: " + reason
+		message += "\nПричина: " + reason
+	}
+	closeRequestCard(ctx, s, cfg, req, status)
+	notifyUser(ctx, s, cfg, req.UserID, message)
+	r.Reply(fmt.Sprintf("Заявка #%d отклонена.", req.ID))
+}
+
+// closeRequestCard дописывает решение в карточку заявки и убирает с неё кнопки
+func closeRequestCard(ctx context.Context, s *discordgo.Session, cfg *config.Config, req *database.RoleRequest, status string) {
+	if req.MessageID == "" {
+		return
+	}
+
+	content := fmt.Sprintf("📝 Заявка #%d: <@%s> хочет получить роль **%s**.\n%s", req.ID, req.UserID, req.RoleName, status)
+	emptyComponents := []discordgo.MessageComponent{}
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		Channel:         cfg.StaffChannelID,
+		ID:              req.MessageID,
+		Content:         &content,
+		Components:      &emptyComponents,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating role request card", "request_id", req.ID, logging.Err(err))
+	}
+}
+
+// isModerator проверяет, что нажавший кнопку может управлять ролями
+func isModerator(i *discordgo.InteractionCreate) bool {
+	return i.Member != nil && i.Member.Permissions&discordgo.PermissionManageRoles != 0
+}
+
+// modalTextValue достаёт значение текстового поля из отправленного модального окна
+func modalTextValue(i *discordgo.InteractionCreate, inputID string) string {
+	for _, row := range i.ModalSubmitData().Components {
+		actionsRow, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range actionsRow.Components {
+			if input, ok := component.(*discordgo.TextInput); ok && input.CustomID == inputID {
+				return input.Value
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/database"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"neble_2/scheduler"
+	"neble_2/webhooks"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Операции над назначениями ролей, общие для кнопок Discord и админского API.
+// Сначала фиксируется изменение в БД вместе с событием для вебхуков, затем идёт запрос
+// к Discord: транзакция и блокировки не ждут Discord. Если Discord отказал, изменение
+// в БД отменяется, и об отмене тоже уходит событие.
+
+// ErrAssignmentInactive - запись уже снята, менять её нельзя
+var ErrAssignmentInactive = errors.New("assignment is not active")
+
+// assignRole записывает назначение внутри транзакции tx с проверкой лимита мест.
+// Возвращает созданную запись; если у пользователя уже есть активная роль -
+// database.ErrActiveRoleExists. Роль в Discord выдаёт grantAssignedRole после коммита.
+func assignRole(ctx context.Context, tx *database.DB, cfg *config.Config, userID, userName string, role config.RoleDefinition) (*database.UserRole, error) {
+	if err := checkCapacity(ctx, tx, cfg, role, userID); err != nil {
+		return nil, err
+	}
+
+	granted, err := tx.AssignRole(ctx, cfg.GuildID, userID, userName, role.ID, role.Name, time.Now().Add(cfg.RoleDuration))
+	if err != nil {
+		return nil, err
+	}
+	if err := webhooks.Publish(ctx, tx, cfg, metrics.EventGranted, granted); err != nil {
+		return nil, err
+	}
+	return granted, nil
+}
+
+// grantAssignedRole выдаёт в Discord роль по записи, созданной assignRole, и убирает
+// пользователя из очереди на неё. Если Discord отказал, запись удаляется, а undo
+// (если задан) в той же транзакции отменяет связанные с выдачей изменения.
+func grantAssignedRole(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, granted *database.UserRole, undo func(tx *database.DB) error) error {
+	if err := s.GuildMemberRoleAdd(cfg.GuildID, granted.UserID, granted.RoleID, discordgo.WithContext(ctx)); err != nil {
+		undoErr := db.WithTx(ctx, func(tx *database.DB) error {
+			if err := tx.DeleteUserRole(ctx, granted.ID); err != nil {
+				return err
+			}
+			if err := webhooks.Publish(ctx, tx, cfg, metrics.EventGrantReverted, granted); err != nil {
+				return err
+			}
+			if undo != nil {
+				return undo(tx)
+			}
+			return nil
+		})
+		if undoErr != nil {
+			// Запись без роли в Discord уберёт сверка с Discord
+			slog.ErrorContext(ctx, "Error undoing role assignment", logging.AssignmentID(granted.ID), logging.Err(undoErr))
+		} else {
+			metrics.RoleEvents.WithLabelValues(cfg.GuildID, granted.RoleID, metrics.EventGrantReverted).Inc()
+		}
+		return fmt.Errorf("add role to %s: %w", granted.UserID, err)
+	}
+
+	// Место из очереди занято - запись очереди больше не нужна
+	if err := db.LeaveWaitlist(ctx, granted.UserID, granted.RoleID); err != nil {
+		slog.ErrorContext(ctx, "Error leaving waitlist", logging.UserID(granted.UserID), logging.Err(err))
+	}
+	return nil
+}
+
+// revokeRole снимает роль: деактивирует запись с причиной reason и событием lifecycle
+// (metrics.Event*), затем снимает роль в Discord, а в льготный период - и метку
+// неактивности. Если Discord отказал, запись снова становится активной. Ушедший
+// с сервера участник роли уже лишился.
+func revokeRole(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, role *database.UserRole, reason, lifecycle string) error {
+	err := db.WithTx(ctx, func(tx *database.DB) error {
+		if err := tx.DeactivateRoleWithReason(ctx, role.ID, reason); err != nil {
+			return fmt.Errorf("deactivate role %d: %w", role.ID, err)
+		}
+		return webhooks.Publish(ctx, tx, cfg, lifecycle, role)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.GuildMemberRoleRemove(cfg.GuildID, role.UserID, role.RoleID, discordgo.WithContext(ctx))
+	if err != nil && !scheduler.IsDiscordError(err, discordgo.ErrCodeUnknownMember) {
+		undoErr := db.WithTx(ctx, func(tx *database.DB) error {
+			if err := tx.ReactivateRole(ctx, role.ID, role.RenewalStatus); err != nil {
+				return err
+			}
+			return webhooks.Publish(ctx, tx, cfg, metrics.EventRemovalReverted, role)
+		})
+		if undoErr != nil {
+			slog.ErrorContext(ctx, "Error reactivating role", logging.AssignmentID(role.ID), logging.Err(undoErr))
+		} else {
+			metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventRemovalReverted).Inc()
+		}
+		return fmt.Errorf("remove role %s from user %s: %w", role.RoleID, role.UserID, err)
+	}
+	if role.RenewalStatus == "grace" {
+		removeInactiveRole(ctx, s, cfg, role.UserID)
+	}
+	return nil
+}
+
+// extendRole продлевает роль до until с событием lifecycle (metrics.Event*) и возвращает
+// её пользователю, если она была снята на льготный период. Если запись успели снять или
+// перевести в другой статус, возвращает ErrAssignmentInactive и в Discord не обращается.
+// Сообщение о продлении остаётся вызывающему.
+func extendRole(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, role *database.UserRole, until time.Time, lifecycle string) error {
+	extended := *role
+	extended.ExpiresAt = until
+	err := db.WithTx(ctx, func(tx *database.DB) error {
+		if err := tx.ExtendRole(ctx, role.ID, role.RenewalStatus, until); err != nil {
+			return err
+		}
+		return webhooks.Publish(ctx, tx, cfg, lifecycle, &extended)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		return ErrAssignmentInactive
+	}
+	if err != nil {
+		return err
+	}
+
+	// Убеждаемся, что роль все еще выдана пользователю
+	if err := s.GuildMemberRoleAdd(cfg.GuildID, role.UserID, role.RoleID, discordgo.WithContext(ctx)); err != nil {
+		slog.WarnContext(ctx, "Error re-adding role", logging.Err(err))
+		// Продолжаем, так как роль могла быть уже выдана
+	}
+
+	// Восстановление из льготного периода: снимаем метку неактивности
+	if role.RenewalStatus == "grace" {
+		removeInactiveRole(ctx, s, cfg, role.UserID)
+	}
+	return nil
+}
+
+// GrantRole выдаёт роль из каталога сервера так же, как одобренная заявка:
+// с лимитом мест и очередью, но без проверки условий роли
+func GrantRole(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, userID, userName string, role config.RoleDefinition) (*database.UserRole, error) {
+	var granted *database.UserRole
+	err := db.WithTx(ctx, func(tx *database.DB) error {
+		var err error
+		granted, err = assignRole(ctx, tx, cfg, userID, userName, role)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := grantAssignedRole(ctx, s, db, cfg, granted, nil); err != nil {
+		return nil, err
+	}
+
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.ID, metrics.EventGranted).Inc()
+	return granted, nil
+}
+
+// RevokeRole снимает активную роль и удаляет висящий вопрос о продлении
+func RevokeRole(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, role *database.UserRole, reason string) error {
+	if !role.IsActive {
+		return ErrAssignmentInactive
+	}
+	if err := revokeRole(ctx, s, db, cfg, role, reason, metrics.EventRevoked); err != nil {
+		return err
+	}
+
+	scheduler.DeleteRenewalMessage(ctx, s, cfg, role.ID, db)
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventRevoked).Inc()
+	return nil
+}
+
+// ExtendRole продлевает активную роль до until, в том числе из льготного периода,
+// и удаляет висящий вопрос о продлении
+func ExtendRole(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, role *database.UserRole, until time.Time) error {
+	if !role.IsActive {
+		return ErrAssignmentInactive
+	}
+	if err := extendRole(ctx, s, db, cfg, role, until, metrics.EventExtended); err != nil {
+		return err
+	}
+
+	scheduler.DeleteRenewalMessage(ctx, s, cfg, role.ID, db)
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventExtended).Inc()
+	return nil
+}
@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"neble_2/config"
+	"neble_2/customid"
 	"neble_2/database"
+	"neble_2/eligibility"
+	"neble_2/guilds"
+	"neble_2/lifecycle"
+	"neble_2/logging"
+	"neble_2/metrics"
 	"neble_2/scheduler"
-	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -14,115 +21,275 @@ import (
 
 const ChangeRoleDuration = 1 * time.Minute
 
-func InteractionCreate(db *database.DB, cfg *config.Config) func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	return func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		switch i.Type {
-		case discordgo.InteractionMessageComponent:
-			data := i.MessageComponentData()
-
-			if strings.HasPrefix(data.CustomID, "select_role_") {
-				handleRoleSelection(s, i, db, cfg, data.CustomID)
-			} else if strings.HasPrefix(data.CustomID, "renew_") {
-				handleRenewalResponse(s, i, db, cfg, data.CustomID)
-			} else if data.CustomID == "change_role" {
-				handleRenewalResponse(s, i, db, cfg, data.CustomID)
-			} else if data.CustomID == "remove_role" { // ДОБАВЛЯЕМ
-				handleRemoveRole(s, i, db, cfg)
+// interactionTimeout ограничивает работу одного обработчика, чтобы зависшая база
+// не держала горутины обработки событий гейтвея
+const interactionTimeout = 10 * time.Second
+
+// InteractionCreate собирает маршрутизатор со всеми обработчиками бота
+func InteractionCreate(db *database.DB, registry *guilds.Registry, codec *customid.Codec, life *lifecycle.Manager, refreshStats func()) func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	rt := NewRouter(codec, registry)
+	rt.SetLifecycle(life)
+	cooldowns := NewCooldowns(cooldownDurations(registry.Base()))
+	rt.SetCooldowns(cooldowns)
+	registry.OnReload(func(base *config.Config) {
+		cooldowns.SetDurations(cooldownDurations(base))
+	})
+
+	// Кнопки панели, очереди и заявок есть только на сервере, поэтому req.Config задан
+	rt.Component(customid.ActionSelectRole, func(ctx context.Context, req *Request) {
+		handleRoleSelection(ctx, req.Session, req.Interaction, req.Responder, db, req.Config, codec, req.ID.Arg)
+	}, Cooldown(CooldownSelect))
+	// Кнопку очереди показывают сразу после нажатия на роль, поэтому общий с ним
+	// кулдаун отклонял бы первое же нажатие; повторная запись в очередь ничего не меняет
+	rt.Component(customid.ActionJoinWaitlist, func(ctx context.Context, req *Request) {
+		handleJoinWaitlist(ctx, req.Interaction, req.Responder, db, req.Config, req.ID.Arg)
+	})
+	rt.Component(customid.ActionWaitlistStatus, func(ctx context.Context, req *Request) {
+		handleWaitlistStatus(ctx, req.Interaction, req.Responder, db, req.Config)
+	})
+
+	// Если включён опрос причины, кнопки отказа сначала спрашивают её - модальным окном
+	// или меню. Опрос можно включить перезагрузкой конфигурации, поэтому маршруты
+	// без автоматического откладывания, а ответ откладывается вручную.
+	rt.Component(customid.ActionRemoveRole, func(ctx context.Context, req *Request) {
+		if base := registry.Base(); base.DropReasonPrompt {
+			promptDropReason(req.Responder, base, codec, dropTarget{})
+			return
+		}
+		if err := req.Responder.Defer(); err != nil {
+			slog.ErrorContext(ctx, "Error deferring interaction response", logging.Err(err))
+			return
+		}
+		handleRemoveRole(ctx, req.Session, req.Interaction, req.Responder, db, req.Config, "")
+	}, Cooldown(CooldownRemove), NoDefer())
+	rt.Component(customid.ActionToggleRenewalDM, func(ctx context.Context, req *Request) {
+		handleToggleRenewalDM(ctx, req.Session, req.Interaction, req.Responder, db, req.Config)
+	})
+	rt.Component(customid.ActionApproveRequest, func(ctx context.Context, req *Request) {
+		handleApproveRequest(ctx, req.Session, req.Interaction, req.Responder, db, req.Config, req.ID)
+	})
+	rt.Component(customid.ActionDenyRequest, func(ctx context.Context, req *Request) {
+		handleDenyRequest(req.Interaction, req.Responder, codec, req.ID)
+	}, NoDefer())
+	rt.Modal(customid.ActionDenyReason, func(ctx context.Context, req *Request) {
+		handleDenyReason(ctx, req.Session, req.Interaction, req.Responder, db, req.Config, req.ID)
+	})
+
+	// Сообщения о продлении приходят и в ЛС: сервер берётся из записи роли
+	for _, action := range []string{customid.ActionRenewYes, customid.ActionRestoreRole} {
+		rt.Component(action, func(ctx context.Context, req *Request) {
+			handleRenewalResponse(ctx, req.Session, req.Interaction, req.Responder, db, registry, req.ID)
+		}, Cooldown(CooldownRenew))
+	}
+	rt.Component(customid.ActionRenewNo, func(ctx context.Context, req *Request) {
+		if base := registry.Base(); base.DropReasonPrompt {
+			recordID, err := req.ID.IntArg()
+			if err != nil {
+				req.Responder.Reply("Ошибка обработки запроса: неверный ID роли")
+				return
 			}
+			promptDropReason(req.Responder, base, codec, dropTarget{renewal: true, recordID: recordID, messageID: req.Interaction.Message.ID})
+			return
+		}
+		if err := req.Responder.Defer(); err != nil {
+			slog.ErrorContext(ctx, "Error deferring interaction response", logging.Err(err))
+			return
 		}
+		handleRenewalResponse(ctx, req.Session, req.Interaction, req.Responder, db, registry, req.ID)
+	}, Cooldown(CooldownRenew), NoDefer())
+	rt.Component(customid.ActionDropReasonPick, func(ctx context.Context, req *Request) {
+		handleDropReasonPick(ctx, req.Session, req.Interaction, req.Responder, db, registry, req.Config, codec, req.ID)
+	}, NoDefer())
+	rt.Modal(customid.ActionDropReason, func(ctx context.Context, req *Request) {
+		handleDropReasonSubmit(ctx, req.Session, req.Interaction, req.Responder, db, registry, req.Config, req.ID)
+	})
+
+	// Мастер настройки сервера. Команда недоступна в ЛС, поэтому req.Config задан
+	wizard := &setupWizard{registry: registry, codec: codec, refreshStats: refreshStats}
+	rt.Command(setupCommandName, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetup(req.Responder, req.Config)
+	}))
+	rt.Component(customid.ActionSetupChannel, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupChannel(ctx, req.Session, req.Interaction, req.Responder, req.ID)
+	}))
+	rt.Component(customid.ActionSetupRoles, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupRoles(req.Responder, req.Config)
+	}))
+	rt.Component(customid.ActionSetupPanelRoles, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupPanelRoles(ctx, req.Session, req.Interaction, req.Responder, req.Config)
+	}))
+	rt.Component(customid.ActionSetupInactiveRole, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupInactiveRole(ctx, req.Session, req.Interaction, req.Responder)
+	}))
+	rt.Component(customid.ActionSetupDurations, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupDurations(req.Interaction, req.Responder)
+	}), NoDefer())
+	rt.Modal(customid.ActionSetupDurationsForm, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupDurationsForm(ctx, req.Session, req.Interaction, req.Responder)
+	}))
+	rt.Component(customid.ActionSetupCatalog, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupCatalog(req.Responder, req.Config)
+	}), NoDefer())
+	rt.Modal(customid.ActionSetupCatalogForm, adminOnly(func(ctx context.Context, req *Request) {
+		wizard.handleSetupCatalogForm(ctx, req.Session, req.Interaction, req.Responder, req.Config)
+	}))
+
+	return rt.Handle
+}
+
+// cooldownDurations - кулдауны групп действий из конфигурации
+func cooldownDurations(cfg *config.Config) map[string]time.Duration {
+	return map[string]time.Duration{
+		CooldownSelect: cfg.CooldownSelect,
+		CooldownRemove: cfg.CooldownRemove,
+		CooldownRenew:  cfg.CooldownRenew,
 	}
 }
 
-func handleRemoveRole(s *discordgo.Session, i *discordgo.InteractionCreate, db *database.DB, cfg *config.Config) {
-	// Получаем текущую активную роль пользователя
-	currentRole, err := db.GetActiveRoleByUserID(i.Member.User.ID)
-	if err != nil || currentRole == nil {
-		respond(s, i, "У вас нет активной роли для удаления.")
+// userError - ошибка, текст которой можно показать пользователю как есть
+type userError string
+
+func (e userError) Error() string {
+	return string(e)
+}
+
+// errAlreadyHasRole - ответ на повторную выдачу, пойманную уникальным индексом
+// (двойной клик или одновременные нажатия на разных панелях)
+var errAlreadyHasRole = userError("У вас уже есть активная роль. Сначала отмените её.")
+
+// respondError отвечает текстом userError или нарушенного условия роли, а для
+// внутренних ошибок - общим сообщением
+func respondError(r *Responder, err error, fallback string) {
+	var ue userError
+	if errors.As(err, &ue) {
+		r.Reply(string(ue))
+		return
+	}
+	var ee eligibility.Error
+	if errors.As(err, &ee) {
+		r.Reply(string(ee))
 		return
 	}
+	slog.ErrorContext(r.ctx, "Interaction failed", "reply", fallback, logging.Err(err))
+	r.ReplyFailure(fallback)
+}
 
-	// Удаляем роль из Discord
-	err = s.GuildMemberRoleRemove(i.GuildID, i.Member.User.ID, currentRole.RoleID)
+// handleRemoveRole снимает активную роль по кнопке на панели; reason - причина, если её спросили
+func handleRemoveRole(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config, reason string) {
+	userID := interactionUser(i).ID
+
+	// Получаем текущую активную роль пользователя
+	removed, err := db.GetActiveRoleByUserID(ctx, cfg.GuildID, userID)
 	if err != nil {
-		log.Printf("Error removing role: %v", err)
-		respond(s, i, "Ошибка при удалении роли")
+		respondError(r, err, "Ошибка при удалении роли")
+		return
+	}
+	if removed == nil {
+		r.Reply("У вас нет активной роли для удаления.")
 		return
 	}
 
-	// Деактивируем роль в БД
-	err = db.DeactivateRole(currentRole.ID)
-	if err != nil {
-		log.Printf("Error deactivating role in DB: %v", err)
-		respond(s, i, "Ошибка при обновлении данных")
+	// Деактивируем роль в БД и удаляем её из Discord; если Discord отказал, роль остаётся
+	if err := revokeRole(ctx, s, db, cfg, removed, reason, metrics.EventDropped); err != nil {
+		respondError(r, err, "Ошибка при удалении роли")
 		return
 	}
 
-	respond(s, i, fmt.Sprintf("Роль **%s** успешно удалена!", currentRole.RoleName))
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, removed.RoleID, metrics.EventDropped).Inc()
+	r.Reply(fmt.Sprintf("Роль **%s** успешно удалена!", removed.RoleName))
 }
 
-func handleRoleSelection(s *discordgo.Session, i *discordgo.InteractionCreate, db *database.DB, cfg *config.Config, customID string) {
-	// ПРОВЕРЯЕМ ЕСТЬ ЛИ УЖЕ АКТИВНАЯ РОЛЬ
-	existingRole, err := db.GetUserRole(i.Member.User.ID)
-	if err != nil && !strings.Contains(err.Error(), "not found") {
-		log.Printf("Error checking existing role: %v", err)
-		respond(s, i, "Ошибка при проверке ролей")
+// handleToggleRenewalDM переключает доставку вопросов о продлении в личные сообщения
+func handleToggleRenewalDM(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config) {
+	userID := interactionUser(i).ID
+
+	enabled := cfg.RenewalDMDefault
+	pref, err := db.GetRenewalDMPreference(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting DM preference", logging.Err(err))
+		r.ReplyFailure("Ошибка при получении настроек")
 		return
 	}
+	if pref != nil {
+		enabled = *pref
+	}
 
-	if existingRole != nil && existingRole.IsActive {
-		respond(s, i, fmt.Sprintf("У вас уже есть активная роль **%s**. Сначала отмените её.", existingRole.RoleName))
+	err = db.SetRenewalDMPreference(ctx, userID, !enabled)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving DM preference", logging.Err(err))
+		r.ReplyFailure("Ошибка при сохранении настроек")
 		return
 	}
 
-	// Маппинг кнопок на роли
-	roles := map[string]struct {
-		ID   string
-		Name string
-	}{
-		"select_role_1": {ID: "1439750973861007442", Name: "Сенди-Шорс"},
-		"select_role_2": {ID: "1439751278925316116", Name: "ХПалето-Бэй"},
+	if !enabled {
+		r.Reply("Теперь вопросы о продлении роли будут приходить вам в личные сообщения. Если ЛС закрыты, бот напишет в канал уведомлений.")
+	} else {
+		r.Reply("Теперь вопросы о продлении роли будут приходить в канал уведомлений.")
 	}
+}
+
+func handleRoleSelection(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config, codec *customid.Codec, roleKey string) {
+	user := interactionUser(i)
 
-	role, exists := roles[customID]
+	role, exists := cfg.RoleByKey(roleKey)
 	if !exists {
-		respond(s, i, "Неизвестная роль")
+		r.Reply("Неизвестная роль")
 		return
 	}
 
-	expiresAt := time.Now().Add(cfg.RoleDuration)
-
-	// Добавляем роль пользователю в Discord
-	err = s.GuildMemberRoleAdd(cfg.GuildID, i.Member.User.ID, role.ID)
+	member, err := interactionMember(ctx, s, i, cfg.GuildID)
 	if err != nil {
-		log.Printf("Error adding role: %v", err)
-		respond(s, i, "Ошибка при выдаче роли")
+		slog.ErrorContext(ctx, "Error getting member", logging.Err(err))
+		r.ReplyFailure("Ошибка при проверке условий роли")
+		return
+	}
+	if err := eligibility.Check(member, role, 0, time.Now()); err != nil {
+		respondError(r, err, "Ошибка при проверке условий роли")
 		return
 	}
 
-	// ЕСЛИ УЖЕ ЕСТЬ ЗАПИСЬ - ОБНОВЛЯЕМ, ЕСЛИ НЕТ - СОЗДАЕМ
-	if existingRole != nil {
-		// ОБНОВЛЯЕМ СУЩЕСТВУЮЩУЮ ЗАПИСЬ
-		err = db.UpdateUserRole(i.Member.User.ID, role.ID, role.Name, expiresAt)
+	// Роли с одобрением выдаёт модератор - создаём заявку вместо выдачи
+	if role.RequiresApproval {
+		submitRoleRequest(ctx, s, r, db, cfg, codec, user, role)
+		return
+	}
+
+	var granted *database.UserRole
+	err = db.WithTx(ctx, func(tx *database.DB) error {
+		// ПРОВЕРЯЕМ ЕСТЬ ЛИ УЖЕ АКТИВНАЯ РОЛЬ
+		existingRole, err := tx.GetActiveRoleByUserID(ctx, cfg.GuildID, user.ID)
 		if err != nil {
-			log.Printf("Error updating role in DB: %v", err)
-			s.GuildMemberRoleRemove(i.GuildID, i.Member.User.ID, role.ID)
-			respond(s, i, "Ошибка при обновлении данных")
-			return
+			return fmt.Errorf("check existing role: %w", err)
 		}
-	} else {
-		// СОЗДАЕМ НОВУЮ ЗАПИСЬ
-		err = db.AddUserRole(i.Member.User.ID, i.Member.User.Username, role.ID, role.Name, expiresAt)
-		if err != nil {
-			log.Printf("Error saving to DB: %v", err)
-			s.GuildMemberRoleRemove(i.GuildID, i.Member.User.ID, role.ID)
-			respond(s, i, "Ошибка при сохранении данных")
-			return
+
+		if existingRole != nil {
+			return userError(fmt.Sprintf("У вас уже есть активная роль **%s**. Сначала отмените её.", existingRole.RoleName))
+		}
+
+		// Лимит мест и запись - как при любой выдаче роли; роль в Discord - после коммита
+		granted, err = assignRole(ctx, tx, cfg, user.ID, user.Username, role)
+		if errors.Is(err, database.ErrActiveRoleExists) {
+			return errAlreadyHasRole
 		}
+		return err
+	})
+	if errors.Is(err, ErrRoleFull) {
+		replyRoleFull(r, codec, role)
+		return
 	}
+	if err == nil {
+		err = grantAssignedRole(ctx, s, db, cfg, granted, nil)
+	}
+	if err != nil {
+		respondError(r, err, "Ошибка при выдаче роли")
+		return
+	}
+
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.ID, metrics.EventGranted).Inc()
 
 	// sendChangeConfirmation(s, i, db, cfg, role.Name)
 
-	respond(s, i, fmt.Sprintf("Роль **%s** успешно выдана!", role.Name))
+	r.Reply(fmt.Sprintf("Роль **%s** успешно выдана!", role.Name))
 }
 
 // func sendChangeConfirmation(s *discordgo.Session, i *discordgo.InteractionCreate, db *database.DB, cfg *config.Config, roleName string) {
@@ -156,59 +323,78 @@ func handleRoleSelection(s *discordgo.Session, i *discordgo.InteractionCreate, d
 // 	go startChangeTimer(s, i.ChannelID, i.Member.User.ID)
 // }
 
-func handleRenewalResponse(s *discordgo.Session, i *discordgo.InteractionCreate, db *database.DB, cfg *config.Config, customID string) {
-	log.Printf("Processing customID: %s", customID)
+func handleRenewalResponse(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, registry *guilds.Registry, id customid.ID) {
+	roleID, err := id.IntArg()
+	if err != nil {
+		slog.WarnContext(ctx, "Invalid renewal record ID", "arg", id.Arg, logging.Err(err))
+		r.Reply("Ошибка обработки запроса: неверный ID роли")
+		return
+	}
 
-	// if customID == "change_role" {
-	// 	handleChangeRole(s, i, db, cfg)
-	// 	return
-	// }
+	// Кнопка восстановления приходит в льготный период, остальные - с вопросом о продлении
+	status := "waiting_response"
+	if id.Action == customid.ActionRestoreRole {
+		status = "grace"
+	}
 
-	// Парсим customID чтобы извлечь действие и ID записи
-	var action string
-	var roleID int
+	// В ЛС сервера у взаимодействия нет - он известен только из записи
+	ctx = logging.With(ctx, logging.KeyAssignmentID, roleID)
+	slog.InfoContext(ctx, "Processing renewal action", "action", id.Action)
 
-	// Ожидаемый формат: "renew_yes_123" или "renew_no_123"
-	parts := strings.Split(customID, "_")
-	if len(parts) < 3 {
-		log.Printf("Invalid customID format: %s", customID)
-		respond(s, i, "Ошибка обработки запроса: неверный формат")
+	role, ok := pendingRenewal(ctx, s, i, r, db, roleID, i.Message.ID, status)
+	if !ok {
 		return
 	}
-
-	action = parts[1] // "yes" или "no"
-
-	// Берем последнюю часть как ID (может содержать дополнительные символы)
-	idStr := parts[2]
-	_, err := fmt.Sscanf(idStr, "%d", &roleID)
-	if err != nil {
-		log.Printf("Error parsing role ID from %s: %v", customID, err)
-		respond(s, i, "Ошибка обработки запроса: неверный ID роли")
+	ctx = logging.With(ctx, logging.KeyGuildID, role.GuildID)
+	cfg, ok := registry.Get(role.GuildID)
+	if !ok {
+		slog.WarnContext(ctx, "Renewal record belongs to unknown guild")
+		r.Reply("Сервер этой роли больше не обслуживается ботом")
 		return
 	}
 
+	switch id.Action {
+	case customid.ActionRenewYes, customid.ActionRestoreRole:
+		handleRenewalYes(ctx, s, i, r, db, cfg, role)
+	case customid.ActionRenewNo:
+		handleRenewalNo(ctx, s, i, r, db, cfg, role, i.Message.ID, "")
+	default:
+		r.Reply("Неизвестное действие")
+	}
+}
+
+// pendingRenewal загружает запись, по которой пользователь отвечает на сообщение о продлении,
+// и проверяет, что сообщение адресовано ему, ещё актуально и запись в статусе status.
+// При отказе ответ уже отправлен.
+func pendingRenewal(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, roleID int, renewalMessageID, status string) (*database.UserRole, bool) {
 	// Получаем запись из базы данных
-	role, err := db.GetRoleByID(roleID)
+	role, err := db.GetRoleByID(ctx, roleID)
 	if err != nil {
-		log.Printf("Error getting role %d: %v", roleID, err)
-		respond(s, i, "Ошибка: запись не найдена")
-		return
+		slog.ErrorContext(ctx, "Error getting role record", logging.Err(err))
+		r.ReplyFailure("Ошибка: запись не найдена")
+		return nil, false
 	}
 
 	// Проверяем, принадлежит ли роль пользователю, который нажал кнопку
-	if i.Member.User.ID != role.UserID {
-		respond(s, i, "Это действие вам недоступно!")
-		return
+	if interactionUser(i).ID != role.UserID {
+		r.Reply("Это действие вам недоступно!")
+		return nil, false
 	}
 
-	switch action {
-	case "yes":
-		handleRenewalYes(s, i, db, cfg, role)
-	case "no":
-		handleRenewalNo(s, i, db, cfg, role)
-	default:
-		respond(s, i, "Неизвестное действие")
+	// Кнопки со старых вопросов о продлении больше не действуют
+	_, messageID, err := db.GetRenewalMessage(ctx, roleID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting renewal message", logging.Err(err))
+		r.ReplyFailure("Ошибка при проверке запроса")
+		return nil, false
+	}
+	if !role.IsActive || role.RenewalStatus != status || messageID != renewalMessageID {
+		r.Reply("Этот вопрос о продлении уже неактуален.")
+		removeButtonsFromMessage(ctx, s, i.ChannelID, renewalMessageID)
+		return nil, false
 	}
+
+	return role, true
 }
 
 // ДОБАВЛЯЕМ ФУНКЦИЮ ОБРАБОТКИ ИЗМЕНЕНИЯ РОЛИ
@@ -251,67 +437,85 @@ func handleRenewalResponse(s *discordgo.Session, i *discordgo.InteractionCreate,
 // 	log.Printf("Change period expired for user %s in channel %s", userID, channelID)
 // }
 
-func handleRenewalYes(s *discordgo.Session, i *discordgo.InteractionCreate, db *database.DB, cfg *config.Config, role *database.UserRole) {
+func handleRenewalYes(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config, role *database.UserRole) {
+	// Условия роли могли измениться с момента выдачи - проверяем их заново
+	if definition, ok := cfg.RoleByID(role.RoleID); ok {
+		member, err := s.GuildMember(cfg.GuildID, role.UserID, discordgo.WithContext(ctx))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error getting member", logging.Err(err))
+			r.ReplyFailure("Ошибка при проверке условий роли")
+			return
+		}
+		if err := eligibility.Check(member, definition, role.RenewalCount, time.Now()); err != nil {
+			respondError(r, err, "Ошибка при проверке условий роли")
+			return
+		}
+	}
+
 	// Продлеваем роль - добавляем еще одну неделю
 	newExpiresAt := time.Now().Add(cfg.RoleDuration)
 
-	// Обновляем дату окончания в БД
-	err := db.ExtendRole(role.ID, newExpiresAt)
-	if err != nil {
-		log.Printf("Error extending role %d: %v", role.ID, err)
-		respond(s, i, "Ошибка при продлении роли")
-		return
+	event := metrics.EventRenewed
+	if role.RenewalStatus == "grace" {
+		event = metrics.EventRestored
 	}
 
-	// Убеждаемся, что роль все еще выдана пользователю
-	err = s.GuildMemberRoleAdd(cfg.GuildID, role.UserID, role.RoleID)
+	// Обновляем дату окончания в БД и возвращаем роль, если она была снята на льготный период
+	err := extendRole(ctx, s, db, cfg, role, newExpiresAt, event)
+	if errors.Is(err, ErrAssignmentInactive) {
+		// Пока шли проверки, роль сняли или вопрос о продлении закрыли по таймауту
+		r.Reply("Этот вопрос о продлении уже неактуален.")
+		removeButtonsFromMessage(ctx, s, i.ChannelID, i.Message.ID)
+		return
+	}
 	if err != nil {
-		log.Printf("Error re-adding role: %v", err)
-		// Продолжаем, так как роль могла быть уже выдана
+		slog.ErrorContext(ctx, "Error extending role", logging.Err(err))
+		r.ReplyFailure("Ошибка при продлении роли")
+		return
 	}
 
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, event).Inc()
+
 	// Отправляем подтверждение
-	respond(s, i, fmt.Sprintf("Роль **%s** успешно продлена до %s!",
+	r.Reply(fmt.Sprintf("Роль **%s** успешно продлена до %s!",
 		role.RoleName, newExpiresAt.Format("02.01.2006 15:04")))
 
 	// Удаляем кнопки из оригинального сообщения
-	removeButtonsFromMessage(s, i.ChannelID, i.Message.ID)
+	removeButtonsFromMessage(ctx, s, i.ChannelID, i.Message.ID)
 
 	// УДАЛЯЕМ СООБЩЕНИЕ О ПРОДЛЕНИИ
-	scheduler.DeleteRenewalMessage(s, cfg, role.ID, db)
-
-	// Отправляем приватное подтверждение
-	respond(s, i, fmt.Sprintf("Роль **%s** успешно продлена до %s!",
-		role.RoleName, newExpiresAt.Format("02.01.2006 15:04")))
+	scheduler.DeleteRenewalMessage(ctx, s, cfg, role.ID, db)
 }
 
-func handleRenewalNo(s *discordgo.Session, i *discordgo.InteractionCreate, db *database.DB, cfg *config.Config, role *database.UserRole) {
-	// Убираем роль у пользователя
-	err := s.GuildMemberRoleRemove(cfg.GuildID, role.UserID, role.RoleID)
-	if err != nil {
-		log.Printf("Error removing role: %v", err)
-		// Продолжаем, чтобы обновить статус в БД
-	}
-
-	// Обновляем статус в БД
-	err = db.DeactivateRole(role.ID)
-	if err != nil {
-		log.Printf("Error deactivating role %d: %v", role.ID, err)
-		respond(s, i, "Ошибка при удалении роли")
+// handleRenewalNo снимает роль после отказа от продления. messageID - вопрос о продлении,
+// с которого убираются кнопки; reason - причина отказа, если её спросили.
+func handleRenewalNo(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config, role *database.UserRole, messageID, reason string) {
+	// Если Discord не ответил, запись остаётся активной
+	if err := revokeRole(ctx, s, db, cfg, role, reason, metrics.EventDropped); err != nil {
+		respondError(r, err, "Ошибка при удалении роли")
 		return
 	}
 
-	respond(s, i, fmt.Sprintf("Роль **%s** была успешно удалена.", role.RoleName))
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventDropped).Inc()
+	r.Reply(fmt.Sprintf("Роль **%s** была успешно удалена.", role.RoleName))
 
 	// Удаляем кнопки из оригинального сообщения
-	removeButtonsFromMessage(s, i.ChannelID, i.Message.ID)
+	removeButtonsFromMessage(ctx, s, i.ChannelID, messageID)
 
-	scheduler.DeleteRenewalMessage(s, cfg, role.ID, db)
+	scheduler.DeleteRenewalMessage(ctx, s, cfg, role.ID, db)
+}
 
-	respond(s, i, fmt.Sprintf("Роль **%s** была успешно удалена.", role.RoleName))
+// removeInactiveRole снимает метку неактивности, выданную на льготный период
+func removeInactiveRole(ctx context.Context, s *discordgo.Session, cfg *config.Config, userID string) {
+	if cfg.InactiveRoleID == "" {
+		return
+	}
+	if err := s.GuildMemberRoleRemove(cfg.GuildID, userID, cfg.InactiveRoleID, discordgo.WithContext(ctx)); err != nil {
+		slog.ErrorContext(ctx, "Error removing inactive role", logging.UserID(userID), logging.Err(err))
+	}
 }
 
-func removeButtonsFromMessage(s *discordgo.Session, channelID, messageID string) {
+func removeButtonsFromMessage(ctx context.Context, s *discordgo.Session, channelID, messageID string) {
 	// Создаем пустой слайс компонентов и передаем его указатель
 	emptyComponents := []discordgo.MessageComponent{}
 
@@ -319,21 +523,25 @@ func removeButtonsFromMessage(s *discordgo.Session, channelID, messageID string)
 		Channel:    channelID,
 		ID:         messageID,
 		Components: &emptyComponents, // Передаем указатель на пустой массив
-	})
+	}, discordgo.WithContext(ctx))
 	if err != nil {
-		log.Printf("Error removing buttons: %v", err)
+		slog.ErrorContext(ctx, "Error removing buttons", "message_id", messageID, logging.Err(err))
 	}
 }
 
-func respond(s *discordgo.Session, i *discordgo.InteractionCreate, message string) {
-	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-		Type: discordgo.InteractionResponseChannelMessageWithSource,
-		Data: &discordgo.InteractionResponseData{
-			Content: message,
-			Flags:   discordgo.MessageFlagsEphemeral,
-		},
-	})
-	if err != nil {
-		log.Printf("Error responding to interaction: %v", err)
+// interactionMember возвращает участника сервера, нажавшего кнопку. В ЛС
+// i.Member отсутствует, тогда участник запрашивается у Discord.
+func interactionMember(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, guildID string) (*discordgo.Member, error) {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member, nil
+	}
+	return s.GuildMember(guildID, interactionUser(i).ID, discordgo.WithContext(ctx))
+}
+
+// interactionUser возвращает автора взаимодействия: в ЛС i.Member отсутствует, там заполнен i.User
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
 	}
+	return i.User
 }
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/customid"
+	"neble_2/database"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// dropReasonInputID - поле причины в модальном окне
+	dropReasonInputID = "reason"
+	// dropReasonOther - пункт меню, открывающий ввод причины текстом
+	dropReasonOther = "__other__"
+)
+
+// dropTarget - какую роль пользователь сдаёт, пока бот спрашивает причину.
+// Кодируется в аргумент custom_id: "remove" для кнопки на панели или
+// "renew-<id записи>-<id сообщения>" для отказа от продления.
+type dropTarget struct {
+	renewal   bool
+	recordID  int
+	messageID string
+}
+
+func (t dropTarget) encode() string {
+	if !t.renewal {
+		return "remove"
+	}
+	return fmt.Sprintf("renew-%d-%s", t.recordID, t.messageID)
+}
+
+func parseDropTarget(arg string) (dropTarget, error) {
+	if arg == "remove" {
+		return dropTarget{}, nil
+	}
+
+	parts := strings.Split(arg, "-")
+	if len(parts) != 3 || parts[0] != "renew" {
+		return dropTarget{}, fmt.Errorf("invalid drop target %q", arg)
+	}
+	recordID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return dropTarget{}, fmt.Errorf("invalid drop target %q: %w", arg, err)
+	}
+	return dropTarget{renewal: true, recordID: recordID, messageID: parts[2]}, nil
+}
+
+// promptDropReason спрашивает причину отказа от роли: меню с вариантами из
+// конфигурации или, если вариантов нет, модальное окно со свободным текстом.
+// Маршрут, который его вызывает, регистрируется с NoDefer.
+func promptDropReason(r *Responder, cfg *config.Config, codec *customid.Codec, target dropTarget) {
+	if len(cfg.DropReasonOptions) == 0 {
+		openDropReasonModal(r, codec, target)
+		return
+	}
+
+	options := make([]discordgo.SelectMenuOption, 0, len(cfg.DropReasonOptions)+1)
+	for _, option := range cfg.DropReasonOptions {
+		options = append(options, discordgo.SelectMenuOption{Label: option, Value: option})
+	}
+	options = append(options, discordgo.SelectMenuOption{Label: "Другое (написать)", Value: dropReasonOther})
+
+	pickID, err := codec.Encode(customid.ActionDropReasonPick, target.encode())
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии формы")
+		return
+	}
+	r.ReplyWithComponents("Почему вы отказываетесь от роли? Ответ увидит только руководство.", []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					MenuType:    discordgo.StringSelectMenu,
+					CustomID:    pickID,
+					Placeholder: "Выберите причину",
+					Options:     options,
+				},
+			},
+		},
+	})
+}
+
+func openDropReasonModal(r *Responder, codec *customid.Codec, target dropTarget) {
+	modalID, err := codec.Encode(customid.ActionDropReason, target.encode())
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии формы")
+		return
+	}
+
+	err = r.Modal(modalID, "Отказ от роли", []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:    dropReasonInputID,
+					Label:       "Почему вы отказываетесь от роли?",
+					Style:       discordgo.TextInputParagraph,
+					Placeholder: "Необязательно. Ответ увидит только руководство.",
+					Required:    false,
+					MaxLength:   300,
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.ErrorContext(r.ctx, "Error opening drop reason modal", logging.Err(err))
+	}
+}
+
+// handleDropReasonPick - выбор причины из меню. "Другое" открывает модальное окно,
+// поэтому маршрут без автоматического откладывания ответа.
+func handleDropReasonPick(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, registry *guilds.Registry, cfg *config.Config, codec *customid.Codec, id customid.ID) {
+	target, err := parseDropTarget(id.Arg)
+	if err != nil {
+		slog.WarnContext(ctx, "Invalid drop reason target", logging.Err(err))
+		r.Reply("Ошибка обработки запроса")
+		return
+	}
+
+	values := i.MessageComponentData().Values
+	if len(values) == 0 {
+		r.Reply("Выберите причину из списка")
+		return
+	}
+	if values[0] == dropReasonOther {
+		openDropReasonModal(r, codec, target)
+		return
+	}
+
+	if err := r.Defer(); err != nil {
+		slog.ErrorContext(ctx, "Error deferring interaction response", logging.Err(err))
+		return
+	}
+	dropRole(ctx, s, i, r, db, registry, cfg, target, values[0])
+}
+
+// handleDropReasonSubmit - причина, введённая в модальном окне
+func handleDropReasonSubmit(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, registry *guilds.Registry, cfg *config.Config, id customid.ID) {
+	target, err := parseDropTarget(id.Arg)
+	if err != nil {
+		slog.WarnContext(ctx, "Invalid drop reason target", logging.Err(err))
+		r.Reply("Ошибка обработки запроса")
+		return
+	}
+
+	dropRole(ctx, s, i, r, db, registry, cfg, target, strings.TrimSpace(modalTextValue(i, dropReasonInputID)))
+}
+
+// dropRole снимает роль, когда причина получена. Кнопка на панели есть только
+// на сервере (cfg задан), а отказ от продления может прийти из ЛС - тогда
+// сервер определяется по записи роли.
+func dropRole(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, db *database.DB, registry *guilds.Registry, cfg *config.Config, target dropTarget, reason string) {
+	if !target.renewal {
+		handleRemoveRole(ctx, s, i, r, db, cfg, reason)
+		return
+	}
+
+	ctx = logging.With(ctx, logging.KeyAssignmentID, target.recordID)
+	role, ok := pendingRenewal(ctx, s, i, r, db, target.recordID, target.messageID, "waiting_response")
+	if !ok {
+		return
+	}
+	ctx = logging.With(ctx, logging.KeyGuildID, role.GuildID)
+	cfg, ok = registry.Get(role.GuildID)
+	if !ok {
+		slog.WarnContext(ctx, "Renewal record belongs to unknown guild")
+		r.Reply("Сервер этой роли больше не обслуживается ботом")
+		return
+	}
+	handleRenewalNo(ctx, s, i, r, db, cfg, role, target.messageID, reason)
+}
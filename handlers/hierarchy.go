@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Роль из каталога любой участник может выдать себе кнопкой на панели, поэтому
+// мастер настройки пускает туда только роли, которые настраивающий и так может
+// выдавать сам, а бот - выдавать и снимать.
+
+// roleHierarchy - роли сервера с позициями и то, кто их может выдавать
+type roleHierarchy struct {
+	roles   map[string]*discordgo.Role
+	ownerID string
+	botTop  int // позиция высшей роли бота
+}
+
+func newRoleHierarchy(guild *discordgo.Guild, bot *discordgo.Member) *roleHierarchy {
+	h := &roleHierarchy{roles: make(map[string]*discordgo.Role, len(guild.Roles)), ownerID: guild.OwnerID}
+	for _, role := range guild.Roles {
+		h.roles[role.ID] = role
+	}
+	h.botTop = h.top(bot.Roles)
+	return h
+}
+
+// loadRoleHierarchy получает роли сервера и роли бота
+func loadRoleHierarchy(ctx context.Context, s *discordgo.Session, guildID string) (*roleHierarchy, error) {
+	guild, err := s.Guild(guildID, discordgo.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("get guild %s: %w", guildID, err)
+	}
+	bot, err := s.GuildMember(guildID, s.State.User.ID, discordgo.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("get bot member: %w", err)
+	}
+	return newRoleHierarchy(guild, bot), nil
+}
+
+// top возвращает позицию высшей из ролей roleIDs; 0 - позиция @everyone
+func (h *roleHierarchy) top(roleIDs []string) int {
+	top := 0
+	for _, roleID := range roleIDs {
+		if role := h.roles[roleID]; role != nil {
+			top = max(top, role.Position)
+		}
+	}
+	return top
+}
+
+// checkAssignable возвращает userError, если участник member не может отдать роль
+// roleID под управление бота. Ограничения иерархии Discord не действуют только на владельца сервера.
+func (h *roleHierarchy) checkAssignable(member *discordgo.Member, roleID string) error {
+	role := h.roles[roleID]
+	switch {
+	case role == nil:
+		return userError(fmt.Sprintf("Роль %s не найдена на сервере.", roleID))
+	case role.Managed:
+		return userError(fmt.Sprintf("Роль **%s** управляется интеграцией, её нельзя выдавать через панель.", role.Name))
+	case role.Position >= h.botTop:
+		return userError(fmt.Sprintf("Роль **%s** не ниже высшей роли бота - бот не сможет её выдавать. Поднимите роль бота выше.", role.Name))
+	case member.User.ID != h.ownerID && role.Position >= h.top(member.Roles):
+		return userError(fmt.Sprintf("Роль **%s** не ниже вашей высшей роли - отдать её боту может только тот, чья роль выше.", role.Name))
+	}
+	return nil
+}
+
+// checkBotCanSend возвращает userError, если бот не может писать в канал channelID
+func checkBotCanSend(ctx context.Context, s *discordgo.Session, channelID string) error {
+	perms, err := s.UserChannelPermissions(s.State.User.ID, channelID, discordgo.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("get permissions in channel %s: %w", channelID, err)
+	}
+	const needed = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages
+	if perms&needed != needed {
+		return userError(fmt.Sprintf("Бот не может писать в канал <#%s>: выдайте ему права на просмотр канала и отправку сообщений.", channelID))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestRoleHierarchyCheckAssignable(t *testing.T) {
+	guild := &discordgo.Guild{
+		ID:      "1",
+		OwnerID: "owner",
+		Roles: []*discordgo.Role{
+			{ID: "1", Name: "@everyone", Position: 0},
+			{ID: "10", Name: "Патруль", Position: 1},
+			{ID: "20", Name: "Модератор", Position: 2},
+			{ID: "30", Name: "Бот", Position: 3},
+			{ID: "40", Name: "Админ", Position: 4},
+			{ID: "50", Name: "Бустер", Position: 1, Managed: true},
+		},
+	}
+	h := newRoleHierarchy(guild, &discordgo.Member{Roles: []string{"30"}})
+
+	member := func(id string, roles ...string) *discordgo.Member {
+		return &discordgo.Member{User: &discordgo.User{ID: id}, Roles: roles}
+	}
+
+	tests := []struct {
+		name   string
+		member *discordgo.Member
+		roleID string
+		want   string // фрагмент ошибки; пусто - роль можно отдать боту
+	}{
+		{"below both", member("2", "20"), "10", ""},
+		{"same as invoker", member("2", "20"), "20", "не ниже вашей высшей роли"},
+		{"above invoker", member("2", "10"), "20", "не ниже вашей высшей роли"},
+		{"invoker without roles", member("2"), "10", "не ниже вашей высшей роли"},
+		{"owner bypasses own hierarchy", member("owner"), "20", ""},
+		{"same as bot", member("2", "40"), "30", "не ниже высшей роли бота"},
+		{"above bot", member("owner"), "40", "не ниже высшей роли бота"},
+		{"managed", member("2", "40"), "50", "управляется интеграцией"},
+		{"unknown", member("2", "40"), "99", "не найдена"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.checkAssignable(tt.member, tt.roleID)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("checkAssignable = %v, want nil", err)
+				}
+				return
+			}
+			if _, ok := err.(userError); !ok || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("checkAssignable = %v, want a userError containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// onboardingTimeout ограничивает регистрацию одного сервера
+const onboardingTimeout = 30 * time.Second
+
+// GuildCreate регистрирует серверы, к которым подключён бот. Discord присылает
+// событие для каждого сервера при запуске и при добавлении бота на новый сервер;
+// новый сервер получает настройки по умолчанию и приветствие в системном канале.
+func GuildCreate(registry *guilds.Registry) func(s *discordgo.Session, g *discordgo.GuildCreate) {
+	return func(s *discordgo.Session, g *discordgo.GuildCreate) {
+		if g.Unavailable {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), onboardingTimeout)
+		defer cancel()
+		ctx = logging.WithCorrelationID(logging.With(ctx, logging.KeyGuildID, g.ID))
+
+		_, created, err := registry.Onboard(ctx, g.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error registering guild", logging.Err(err))
+			return
+		}
+		if !created {
+			return
+		}
+
+		slog.InfoContext(ctx, "Joined new guild", "name", g.Name)
+		if g.SystemChannelID == "" {
+			return
+		}
+		_, err = s.ChannelMessageSend(g.SystemChannelID, fmt.Sprintf(
+			"Привет! Я выдаю временные роли и слежу за их продлением. "+
+				"Чтобы начать, выполните команду `/%s` (нужны права на управление сервером и ролями): "+
+				"в ней выбираются канал ролей и роли, которые можно получить на панели.", setupCommandName),
+			discordgo.WithContext(ctx))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error sending welcome message", logging.Err(err))
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Группы действий, для которых действуют отдельные кулдауны
+const (
+	CooldownSelect = "select"
+	CooldownRemove = "remove"
+	CooldownRenew  = "renew"
+)
+
+type cooldownKey struct {
+	userID string
+	group  string
+}
+
+// Cooldowns ограничивает, как часто один пользователь может повторять действие
+type Cooldowns struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+	last      map[cooldownKey]time.Time
+}
+
+func NewCooldowns(durations map[string]time.Duration) *Cooldowns {
+	return &Cooldowns{
+		durations: durations,
+		last:      make(map[cooldownKey]time.Time),
+	}
+}
+
+// Allow отмечает попытку действия и возвращает, сколько ещё ждать, если кулдаун не истёк
+func (c *Cooldowns) Allow(userID, group string, now time.Time) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cooldown := c.durations[group]
+	if cooldown <= 0 {
+		return 0, true
+	}
+
+	key := cooldownKey{userID: userID, group: group}
+	if last, ok := c.last[key]; ok {
+		if wait := last.Add(cooldown).Sub(now); wait > 0 {
+			return wait, false
+		}
+	}
+
+	c.last[key] = now
+	c.prune(now)
+	return 0, true
+}
+
+// SetDurations заменяет длительности кулдаунов при перезагрузке конфигурации.
+// Уже начатые кулдауны отсчитываются по новым значениям.
+func (c *Cooldowns) SetDurations(durations map[string]time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.durations = durations
+}
+
+// prune забывает давно истёкшие кулдауны, чтобы карта не росла бесконечно
+func (c *Cooldowns) prune(now time.Time) {
+	if len(c.last) < 1024 {
+		return
+	}
+	for key, last := range c.last {
+		if now.Sub(last) > c.durations[key.group] {
+			delete(c.last, key)
+		}
+	}
+}
+
+// TokenBucket - общий лимит запросов: rate токенов в секунду, не больше burst подряд
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait ждёт свободный токен или отмены контекста
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait := b.reserve(time.Now())
+		if wait == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve забирает токен, если он есть, иначе возвращает время до появления следующего
+func (b *TokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// RateLimitedTransport пропускает REST-запросы к Discord через общий TokenBucket,
+// чтобы всплеск нажатий не упирался в глобальный лимит Discord. Ответы на
+// взаимодействия в этот лимит не входят и идут без очереди: иначе при всплеске
+// бот не успел бы ответить за три секунды.
+type RateLimitedTransport struct {
+	Base   http.RoundTripper
+	Bucket *TokenBucket
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !exemptFromGlobalLimit(req.URL.Path) {
+		if err := t.Bucket.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// exemptFromGlobalLimit сообщает, что маршрут не входит в глобальный лимит Discord:
+// ответ на взаимодействие (POST /interactions/{id}/{token}/callback) и вебхуки
+// с токеном (/webhooks/{id}/{token}...), через которые идут последующие ответы
+func exemptFromGlobalLimit(path string) bool {
+	// Путь начинается с /api/v{версия}/
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "api" {
+		return false
+	}
+	parts = parts[2:]
+
+	switch parts[0] {
+	case "interactions":
+		return len(parts) == 4 && parts[3] == "callback"
+	case "webhooks":
+		return len(parts) >= 3
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCooldownsAllow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCooldowns(map[string]time.Duration{CooldownSelect: 10 * time.Second})
+
+	steps := []struct {
+		name     string
+		userID   string
+		group    string
+		at       time.Duration
+		wantWait time.Duration
+		wantOK   bool
+	}{
+		{"first attempt", "1", CooldownSelect, 0, 0, true},
+		{"repeat too early", "1", CooldownSelect, 4 * time.Second, 6 * time.Second, false},
+		{"other user", "2", CooldownSelect, 4 * time.Second, 0, true},
+		{"group without cooldown", "1", CooldownRemove, 4 * time.Second, 0, true},
+		{"cooldown expired", "1", CooldownSelect, 10 * time.Second, 0, true},
+		{"cooldown restarted", "1", CooldownSelect, 15 * time.Second, 5 * time.Second, false},
+	}
+
+	for _, step := range steps {
+		wait, ok := c.Allow(step.userID, step.group, now.Add(step.at))
+		if wait != step.wantWait || ok != step.wantOK {
+			t.Errorf("%s: Allow = %v, %t; want %v, %t", step.name, wait, ok, step.wantWait, step.wantOK)
+		}
+	}
+}
+
+func TestCooldownsSetDurations(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := NewCooldowns(map[string]time.Duration{CooldownRenew: time.Minute})
+
+	if _, ok := c.Allow("1", CooldownRenew, now); !ok {
+		t.Fatal("first attempt was rejected")
+	}
+	c.SetDurations(map[string]time.Duration{CooldownRenew: 5 * time.Second})
+	if wait, ok := c.Allow("1", CooldownRenew, now.Add(5*time.Second)); !ok {
+		t.Errorf("attempt after the shortened cooldown was rejected, wait %v", wait)
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	b := &TokenBucket{rate: 2, burst: 3, tokens: 3, last: start}
+
+	steps := []struct {
+		name string
+		at   time.Duration
+		want time.Duration
+	}{
+		{"burst 1", 0, 0},
+		{"burst 2", 0, 0},
+		{"burst 3", 0, 0},
+		{"empty", 0, 500 * time.Millisecond},
+		{"partly refilled", 250 * time.Millisecond, 250 * time.Millisecond},
+		{"refilled", 500 * time.Millisecond, 0},
+		{"empty again", 500 * time.Millisecond, 500 * time.Millisecond},
+		// За минуту набирается не больше burst токенов
+		{"after idle 1", time.Minute, 0},
+		{"after idle 2", time.Minute, 0},
+		{"after idle 3", time.Minute, 0},
+		{"after idle empty", time.Minute, 500 * time.Millisecond},
+	}
+
+	for _, step := range steps {
+		if got := b.reserve(start.Add(step.at)); got != step.want {
+			t.Errorf("%s: reserve = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestNewTokenBucketMinimumBurst(t *testing.T) {
+	b := NewTokenBucket(1, 0)
+	now := time.Now()
+	if got := b.reserve(now); got != 0 {
+		t.Errorf("first reserve = %v, want an immediate token", got)
+	}
+	if got := b.reserve(now); got == 0 {
+		t.Error("second reserve got a token, want burst of 1")
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	t.Run("token available", func(t *testing.T) {
+		b := NewTokenBucket(1, 1)
+		if err := b.Wait(context.Background()); err != nil {
+			t.Errorf("Wait = %v, want nil", err)
+		}
+	})
+
+	t.Run("waits for refill", func(t *testing.T) {
+		b := &TokenBucket{rate: 100, burst: 1, tokens: 0, last: time.Now()}
+		started := time.Now()
+		if err := b.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait = %v, want nil", err)
+		}
+		if elapsed := time.Since(started); elapsed < 5*time.Millisecond {
+			t.Errorf("Wait returned after %v, want about 10ms", elapsed)
+		}
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		b := &TokenBucket{rate: 0.001, burst: 1, tokens: 0, last: time.Now()}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Wait = %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimitedTransportExemptions(t *testing.T) {
+	tests := []struct {
+		method  string
+		path    string
+		limited bool
+	}{
+		{http.MethodPost, "/api/v9/interactions/123/token/callback", false},
+		{http.MethodPatch, "/api/v9/webhooks/456/token/messages/@original", false},
+		{http.MethodPost, "/api/v9/webhooks/456/token", false},
+		{http.MethodGet, "/api/v9/webhooks/456", true},
+		{http.MethodPut, "/api/v9/guilds/1/members/2/roles/3", true},
+		{http.MethodPost, "/api/v9/channels/1/messages", true},
+		{http.MethodGet, "/api/v9/interactions/123/token", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			sent := false
+			transport := &RateLimitedTransport{
+				Base: roundTripFunc(func(*http.Request) (*http.Response, error) {
+					sent = true
+					return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody}, nil
+				}),
+				// Пустое ведро почти без пополнения: лимитированный запрос дождётся только отмены
+				Bucket: &TokenBucket{rate: 0.001, burst: 1, tokens: 0, last: time.Now()},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req, err := http.NewRequestWithContext(ctx, tt.method, "https://discord.com"+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = transport.RoundTrip(req)
+			if tt.limited {
+				if !errors.Is(err, context.Canceled) || sent {
+					t.Errorf("RoundTrip = %v, sent %t; want the request to wait for the bucket", err, sent)
+				}
+			} else if err != nil || !sent {
+				t.Errorf("RoundTrip = %v, sent %t; want the request to bypass the bucket", err, sent)
+			}
+		})
+	}
+}
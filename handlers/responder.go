@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"neble_2/logging"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Responder отвечает на одно взаимодействие. Discord ждёт первый ответ не дольше
+// трёх секунд и принимает его ровно один раз, поэтому обработчики сначала
+// откладывают ответ, а результат отправляют правкой или follow-up сообщением.
+type Responder struct {
+	ctx         context.Context // поля логов взаимодействия и его срок
+	session     *discordgo.Session
+	interaction *discordgo.Interaction
+
+	mu        sync.Mutex
+	responded bool // первичный ответ (отложенный или полный) уже отправлен
+	deferred  bool // первичный ответ был отложенным
+	edited    bool // отложенный ответ уже заменён содержимым
+	failed    bool // обработчик завершился внутренней ошибкой
+}
+
+func newResponder(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) *Responder {
+	return &Responder{ctx: ctx, session: s, interaction: i.Interaction}
+}
+
+// Responded сообщает, использован ли уже первичный ответ на взаимодействие
+func (r *Responder) Responded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.responded
+}
+
+// Defer откладывает ответ: пользователь видит "бот думает...", а у обработчика
+// есть 15 минут на Reply. Повторный вызов ничего не делает.
+func (r *Responder) Defer() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.responded {
+		return nil
+	}
+
+	err := r.session.InteractionRespond(r.interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	}, discordgo.WithContext(r.ctx))
+	if err != nil {
+		return err
+	}
+
+	r.responded = true
+	r.deferred = true
+	return nil
+}
+
+// Reply отправляет пользователю скрытое сообщение тем способом, который ещё доступен:
+// первичным ответом, правкой отложенного ответа или follow-up сообщением
+func (r *Responder) Reply(content string) {
+	r.ReplyWithComponents(content, nil)
+}
+
+// ReplyFailure - Reply с сообщением о внутренней ошибке: взаимодействие
+// учитывается в метриках как неудачное
+func (r *Responder) ReplyFailure(content string) {
+	r.mu.Lock()
+	r.failed = true
+	r.mu.Unlock()
+	r.Reply(content)
+}
+
+// Failed сообщает, завершилось ли взаимодействие внутренней ошибкой
+func (r *Responder) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed
+}
+
+// ReplyWithComponents - Reply с кнопками под сообщением
+func (r *Responder) ReplyWithComponents(content string, components []discordgo.MessageComponent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var err error
+	switch {
+	case !r.responded:
+		err = r.session.InteractionRespond(r.interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content:    content,
+				Components: components,
+				Flags:      discordgo.MessageFlagsEphemeral,
+			},
+		}, discordgo.WithContext(r.ctx))
+		if err == nil {
+			r.responded = true
+		}
+	case r.deferred && !r.edited:
+		edit := &discordgo.WebhookEdit{Content: &content}
+		if components != nil {
+			edit.Components = &components
+		}
+		_, err = r.session.InteractionResponseEdit(r.interaction, edit, discordgo.WithContext(r.ctx))
+		if err == nil {
+			r.edited = true
+		}
+	default:
+		_, err = r.session.FollowupMessageCreate(r.interaction, true, &discordgo.WebhookParams{
+			Content:    content,
+			Components: components,
+			Flags:      discordgo.MessageFlagsEphemeral,
+		}, discordgo.WithContext(r.ctx))
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.ctx, "Error responding to interaction", logging.Err(err))
+	}
+}
+
+// Modal отвечает модальным окном. Это возможно только первичным ответом,
+// поэтому маршрут такого обработчика регистрируется с NoDefer.
+func (r *Responder) Modal(customID, title string, components []discordgo.MessageComponent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.responded {
+		return errors.New("interaction already responded, cannot open modal")
+	}
+
+	err := r.session.InteractionRespond(r.interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID:   customID,
+			Title:      title,
+			Components: components,
+		},
+	}, discordgo.WithContext(r.ctx))
+	if err != nil {
+		return err
+	}
+
+	r.responded = true
+	return nil
+}
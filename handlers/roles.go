@@ -1,58 +1,199 @@
 package handlers
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"neble_2/config"
+	"neble_2/customid"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-var roleMessageID string
-
-func CreateRoleSelectionMessage(s *discordgo.Session, cfg *config.Config) {
-	components := []discordgo.MessageComponent{
-		discordgo.ActionsRow{
-			Components: []discordgo.MessageComponent{
-				discordgo.Button{
-					Label:    "Сенди-Шорс",
-					Style:    discordgo.PrimaryButton,
-					CustomID: "select_role_1",
-				},
-				discordgo.Button{
-					Label:    "Палето-Бэй",
-					Style:    discordgo.SuccessButton,
-					CustomID: "select_role_2",
-				},
-				discordgo.Button{
-					Label:    "Убрать роль",
-					Style:    discordgo.DangerButton,
-					CustomID: "remove_role",
-				},
-			},
-		},
+// commandsTimeout ограничивает регистрацию слэш-команд при подключении к Discord
+const commandsTimeout = 30 * time.Second
+
+// roleMessages - ID панели выбора ролей на каждом сервере
+var roleMessages = struct {
+	sync.Mutex
+	ids map[string]string
+}{ids: make(map[string]string)}
+
+// CreateRoleSelectionMessages публикует панели на всех серверах,
+// где настроены канал ролей и каталог
+func CreateRoleSelectionMessages(ctx context.Context, s *discordgo.Session, registry *guilds.Registry, codec *customid.Codec) {
+	for _, cfg := range registry.All() {
+		if cfg.RoleChannelID == "" || len(cfg.Roles) == 0 {
+			slog.InfoContext(ctx, "Guild has no role channel or catalog yet, skipping role panel", logging.GuildID(cfg.GuildID))
+			continue
+		}
+		CreateRoleSelectionMessage(ctx, s, cfg, codec)
+	}
+}
+
+func CreateRoleSelectionMessage(ctx context.Context, s *discordgo.Session, cfg *config.Config, codec *customid.Codec) {
+	components, err := roleSelectionComponents(cfg, codec)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error building role selection message", logging.GuildID(cfg.GuildID), logging.Err(err))
+		return
 	}
 
 	msg, err := s.ChannelMessageSendComplex(cfg.RoleChannelID, &discordgo.MessageSend{
 		Content:    "Выберите роль:",
 		Components: components,
-	})
+	}, discordgo.WithContext(ctx))
 
 	if err != nil {
-		log.Printf("Error creating role selection message: %v", err)
+		slog.ErrorContext(ctx, "Error creating role selection message", logging.GuildID(cfg.GuildID), logging.Err(err))
+		return
+	}
+
+	roleMessages.Lock()
+	roleMessages.ids[cfg.GuildID] = msg.ID
+	roleMessages.Unlock()
+	slog.InfoContext(ctx, "Role selection message created", logging.GuildID(cfg.GuildID), "message_id", msg.ID)
+}
+
+// RefreshRoleSelectionMessage приводит панель сервера к новой конфигурации: в том же
+// канале сообщение правится на месте, при смене канала панель переносится
+func RefreshRoleSelectionMessage(ctx context.Context, s *discordgo.Session, old, updated *config.Config, codec *customid.Codec) {
+	if old.RoleChannelID == updated.RoleChannelID && reflect.DeepEqual(old.Roles, updated.Roles) {
 		return
 	}
 
-	roleMessageID = msg.ID
-	log.Printf("Role selection message created with ID: %s", roleMessageID)
+	roleMessages.Lock()
+	messageID := roleMessages.ids[updated.GuildID]
+	roleMessages.Unlock()
+
+	if messageID != "" && old.RoleChannelID == updated.RoleChannelID && len(updated.Roles) > 0 {
+		components, err := roleSelectionComponents(updated, codec)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error building role selection message", logging.GuildID(updated.GuildID), logging.Err(err))
+			return
+		}
+		_, err = s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			Channel:    updated.RoleChannelID,
+			ID:         messageID,
+			Components: &components,
+		}, discordgo.WithContext(ctx))
+		if err == nil {
+			slog.InfoContext(ctx, "Role selection message updated", logging.GuildID(updated.GuildID), "message_id", messageID)
+			return
+		}
+		slog.WarnContext(ctx, "Error updating role selection message, recreating it", logging.GuildID(updated.GuildID), logging.Err(err))
+	}
+
+	CleanupRoleMessage(ctx, s, old)
+	if updated.RoleChannelID != "" && len(updated.Roles) > 0 {
+		CreateRoleSelectionMessage(ctx, s, updated, codec)
+	}
+}
+
+// roleSelectionComponents строит кнопки панели из каталога ролей.
+// В одном ряду Discord допускает не больше пяти кнопок.
+func roleSelectionComponents(cfg *config.Config, codec *customid.Codec) ([]discordgo.MessageComponent, error) {
+	var rows []discordgo.MessageComponent
+	var buttons []discordgo.MessageComponent
+
+	for _, role := range cfg.Roles {
+		selectID, err := codec.Encode(customid.ActionSelectRole, role.Key)
+		if err != nil {
+			return nil, err
+		}
+		buttons = append(buttons, discordgo.Button{
+			Label:    role.ButtonLabel(),
+			Style:    buttonStyle(role.Style),
+			CustomID: selectID,
+		})
+		if len(buttons) == 5 {
+			rows = append(rows, discordgo.ActionsRow{Components: buttons})
+			buttons = nil
+		}
+	}
+	if len(buttons) > 0 {
+		rows = append(rows, discordgo.ActionsRow{Components: buttons})
+	}
+
+	removeID, err := codec.Encode(customid.ActionRemoveRole, "")
+	if err != nil {
+		return nil, err
+	}
+	dmID, err := codec.Encode(customid.ActionToggleRenewalDM, "")
+	if err != nil {
+		return nil, err
+	}
+	rows = append(rows, discordgo.ActionsRow{
+		Components: []discordgo.MessageComponent{
+			discordgo.Button{
+				Label:    "Убрать роль",
+				Style:    discordgo.DangerButton,
+				CustomID: removeID,
+			},
+			discordgo.Button{
+				Label:    "Уведомления в ЛС",
+				Style:    discordgo.SecondaryButton,
+				CustomID: dmID,
+			},
+		},
+	})
+
+	// Кнопка очереди нужна, только если есть роли с ограниченным числом мест
+	for _, role := range cfg.Roles {
+		if role.MaxHolders > 0 {
+			statusID, err := codec.Encode(customid.ActionWaitlistStatus, "")
+			if err != nil {
+				return nil, err
+			}
+			controls := rows[len(rows)-1].(discordgo.ActionsRow)
+			controls.Components = append(controls.Components, discordgo.Button{
+				Label:    "Моя очередь",
+				Style:    discordgo.SecondaryButton,
+				CustomID: statusID,
+			})
+			rows[len(rows)-1] = controls
+			break
+		}
+	}
+
+	return rows, nil
 }
 
-func CleanupRoleMessage(s *discordgo.Session, cfg *config.Config) {
-	if roleMessageID != "" {
-		err := s.ChannelMessageDelete(cfg.RoleChannelID, roleMessageID)
+func buttonStyle(style string) discordgo.ButtonStyle {
+	switch style {
+	case "success":
+		return discordgo.SuccessButton
+	case "secondary":
+		return discordgo.SecondaryButton
+	case "danger":
+		return discordgo.DangerButton
+	default:
+		return discordgo.PrimaryButton
+	}
+}
+
+// CleanupRoleMessages удаляет панели выбора ролей на всех серверах
+func CleanupRoleMessages(ctx context.Context, s *discordgo.Session, registry *guilds.Registry) {
+	for _, cfg := range registry.All() {
+		CleanupRoleMessage(ctx, s, cfg)
+	}
+}
+
+func CleanupRoleMessage(ctx context.Context, s *discordgo.Session, cfg *config.Config) {
+	roleMessages.Lock()
+	messageID := roleMessages.ids[cfg.GuildID]
+	delete(roleMessages.ids, cfg.GuildID)
+	roleMessages.Unlock()
+
+	if messageID != "" {
+		err := s.ChannelMessageDelete(cfg.RoleChannelID, messageID, discordgo.WithContext(ctx))
 		if err != nil {
-			log.Printf("Error deleting role message: %v", err)
+			slog.ErrorContext(ctx, "Error deleting role selection message", logging.GuildID(cfg.GuildID), logging.Err(err))
 		} else {
-			log.Printf("Role message %s deleted successfully", roleMessageID)
+			slog.InfoContext(ctx, "Role selection message deleted", logging.GuildID(cfg.GuildID), "message_id", messageID)
 		}
 	}
 }
@@ -60,6 +201,10 @@ func CleanupRoleMessage(s *discordgo.Session, cfg *config.Config) {
 func Ready(s *discordgo.Session, r *discordgo.Ready) {
 	err := s.UpdateGameStatus(0, "Управление ролями")
 	if err != nil {
-		log.Printf("Error updating game status: %v", err)
+		slog.Error("Error updating game status", logging.Err(err))
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandsTimeout)
+	defer cancel()
+	registerCommands(ctx, s, r.User.ID)
 }
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"neble_2/config"
+	"neble_2/customid"
+	"neble_2/guilds"
+	"neble_2/lifecycle"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Request - всё, что нужно обработчику одного взаимодействия
+type Request struct {
+	Session     *discordgo.Session
+	Interaction *discordgo.InteractionCreate
+	Responder   *Responder
+	// ID - разобранный custom_id; заполняется только для компонентов и модальных окон
+	ID customid.ID
+	// Config - конфигурация сервера, на котором произошло взаимодействие.
+	// В личных сообщениях сервера нет и Config равен nil: обработчики, доступные
+	// в ЛС, определяют сервер по своей записи в БД.
+	Config *config.Config
+}
+
+type HandlerFunc func(ctx context.Context, req *Request)
+
+type route struct {
+	handler       HandlerFunc
+	deferResponse bool
+	cooldown      string // группа кулдауна, пустая - без ограничения
+}
+
+type RouteOption func(*route)
+
+// NoDefer отключает автоматическое откладывание ответа - нужно обработчикам,
+// которые отвечают модальным окном или подсказками автодополнения
+func NoDefer() RouteOption {
+	return func(r *route) {
+		r.deferResponse = false
+	}
+}
+
+// Cooldown ограничивает, как часто один пользователь может вызывать маршрут.
+// Маршруты с одной группой делят общий кулдаун.
+func Cooldown(group string) RouteOption {
+	return func(r *route) {
+		r.cooldown = group
+	}
+}
+
+// Router раскладывает взаимодействия по зарегистрированным обработчикам:
+// компоненты и модальные окна - по действию из custom_id, слэш-команды и
+// автодополнение - по имени команды
+type Router struct {
+	codec        *customid.Codec
+	guilds       *guilds.Registry
+	components   map[string]route
+	modals       map[string]route
+	commands     map[string]route
+	autocomplete map[string]route
+	cooldowns    *Cooldowns
+	life         *lifecycle.Manager
+}
+
+func NewRouter(codec *customid.Codec, registry *guilds.Registry) *Router {
+	return &Router{
+		codec:        codec,
+		guilds:       registry,
+		components:   make(map[string]route),
+		modals:       make(map[string]route),
+		commands:     make(map[string]route),
+		autocomplete: make(map[string]route),
+	}
+}
+
+func newRoute(h HandlerFunc, opts []RouteOption) route {
+	r := route{handler: h, deferResponse: true}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// SetCooldowns включает кулдауны для маршрутов, зарегистрированных с Cooldown
+func (rt *Router) SetCooldowns(c *Cooldowns) {
+	rt.cooldowns = c
+}
+
+// SetLifecycle включает учёт обработчиков для плавной остановки: во время остановки
+// новые взаимодействия отклоняются, а начатые дорабатывают
+func (rt *Router) SetLifecycle(life *lifecycle.Manager) {
+	rt.life = life
+}
+
+// Component регистрирует обработчик кнопок и меню выбора с указанным действием
+func (rt *Router) Component(action string, h HandlerFunc, opts ...RouteOption) {
+	rt.components[action] = newRoute(h, opts)
+}
+
+// Modal регистрирует обработчик отправки модального окна с указанным действием
+func (rt *Router) Modal(action string, h HandlerFunc, opts ...RouteOption) {
+	rt.modals[action] = newRoute(h, opts)
+}
+
+// Command регистрирует обработчик слэш-команды
+func (rt *Router) Command(name string, h HandlerFunc, opts ...RouteOption) {
+	rt.commands[name] = newRoute(h, opts)
+}
+
+// Autocomplete регистрирует обработчик автодополнения для слэш-команды.
+// Ответ автодополнения нельзя отложить, поэтому NoDefer подразумевается.
+func (rt *Router) Autocomplete(command string, h HandlerFunc) {
+	rt.autocomplete[command] = route{handler: h}
+}
+
+// Handle - обработчик события InteractionCreate для discordgo
+func (rt *Router) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	root := context.Background()
+	if rt.life != nil {
+		root = rt.life.Context()
+	}
+	ctx, cancel := context.WithTimeout(root, interactionTimeout)
+	defer cancel()
+
+	// Все логи взаимодействия, включая запросы к БД и Discord, получают общий correlation_id
+	ctx = logging.WithCorrelationID(logging.With(ctx,
+		logging.KeyInteractionID, i.ID,
+		logging.KeyUserID, interactionUser(i).ID,
+	))
+	if i.GuildID != "" {
+		ctx = logging.With(ctx, logging.KeyGuildID, i.GuildID)
+	}
+
+	req := &Request{Session: s, Interaction: i, Responder: newResponder(ctx, s, i)}
+
+	// Метрики: маршрут и исход взаимодействия определяются по ходу разбора
+	started := time.Now()
+	routeName, outcome := "unknown", metrics.OutcomeRejected
+	defer func() {
+		if outcome == metrics.OutcomeOK && req.Responder.Failed() {
+			outcome = metrics.OutcomeFailed
+		}
+		metrics.Interactions.WithLabelValues(routeName, outcome).Inc()
+		metrics.InteractionDuration.WithLabelValues(routeName).Observe(time.Since(started).Seconds())
+	}()
+
+	if rt.life != nil {
+		done, ok := rt.life.Begin()
+		if !ok {
+			slog.InfoContext(ctx, "Interaction rejected: shutting down")
+			if i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+				req.Responder.Reply("Бот перезапускается, попробуйте через минуту.")
+			}
+			return
+		}
+		defer done()
+	}
+
+	if i.GuildID != "" {
+		cfg, ok := rt.guilds.Get(i.GuildID)
+		if !ok {
+			slog.WarnContext(ctx, "Interaction from unknown guild")
+			if i.Type != discordgo.InteractionApplicationCommandAutocomplete {
+				req.Responder.Reply("Бот ещё не настроен на этом сервере")
+			}
+			return
+		}
+		req.Config = cfg
+	}
+
+	var (
+		r  route
+		ok bool
+	)
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		r, ok = rt.decodeRoute(ctx, req, rt.components, i.MessageComponentData().CustomID)
+	case discordgo.InteractionModalSubmit:
+		r, ok = rt.decodeRoute(ctx, req, rt.modals, i.ModalSubmitData().CustomID)
+	case discordgo.InteractionApplicationCommand:
+		r, ok = rt.commands[i.ApplicationCommandData().Name]
+		routeName = "/" + i.ApplicationCommandData().Name
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		r, ok = rt.autocomplete[i.ApplicationCommandData().Name]
+		routeName = "/" + i.ApplicationCommandData().Name + ":autocomplete"
+	}
+	if req.ID.Action != "" {
+		routeName = req.ID.Action
+	}
+
+	if !ok {
+		if i.Type != discordgo.InteractionApplicationCommandAutocomplete && !req.Responder.Responded() {
+			req.Responder.Reply("Неизвестное действие")
+		}
+		return
+	}
+
+	// Кулдаун проверяем до откладывания ответа: отказ отправляется сразу и не тратит запросы к БД
+	if r.cooldown != "" && rt.cooldowns != nil {
+		userID := interactionUser(i).ID
+		if wait, allowed := rt.cooldowns.Allow(userID, r.cooldown, time.Now()); !allowed {
+			slog.InfoContext(ctx, "Cooldown hit", "cooldown", r.cooldown, "wait_ms", wait.Milliseconds())
+			req.Responder.Reply(fmt.Sprintf("Слишком часто! Попробуйте снова через %d сек.", int(math.Ceil(wait.Seconds()))))
+			outcome = metrics.OutcomeCooldown
+			return
+		}
+	}
+
+	// Сразу откладываем ответ: дальше идут запросы к БД и Discord,
+	// которые могут не уложиться в три секунды
+	if r.deferResponse {
+		if err := req.Responder.Defer(); err != nil {
+			slog.ErrorContext(ctx, "Error deferring interaction response", logging.Err(err))
+			outcome = metrics.OutcomeFailed
+			return
+		}
+	}
+
+	outcome = metrics.OutcomeOK
+	r.handler(ctx, req)
+}
+
+// decodeRoute проверяет custom_id и находит обработчик по действию.
+// Устаревшие и поддельные custom_id отклоняются с понятным ответом.
+func (rt *Router) decodeRoute(ctx context.Context, req *Request, routes map[string]route, raw string) (route, bool) {
+	id, err := rt.codec.Decode(raw)
+	if err != nil {
+		slog.WarnContext(ctx, "Rejected custom id", "custom_id", raw, logging.Err(err))
+		req.Responder.Reply("Эта кнопка устарела или недействительна. Воспользуйтесь актуальным сообщением.")
+		return route{}, false
+	}
+
+	r, ok := routes[id.Action]
+	if !ok {
+		slog.WarnContext(ctx, "No handler registered for custom id action", "action", id.Action)
+		return route{}, false
+	}
+
+	req.ID = id
+	return r, true
+}
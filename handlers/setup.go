@@ -0,0 +1,554 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/customid"
+	"neble_2/database"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// setupCommandName - слэш-команда мастера настройки сервера
+	setupCommandName = "setup"
+	// maxCatalogInput - ограничение Discord на длину текстового поля модального окна
+	maxCatalogInput = 4000
+)
+
+// Поля модальных окон мастера
+const (
+	setupRoleDurationInput    = "role_duration"
+	setupRenewalDurationInput = "renewal_duration"
+	setupCatalogInput         = "catalog"
+)
+
+// setupChannels - каналы, которые выбираются в мастере; ключ - аргумент custom_id меню
+var setupChannels = []struct {
+	key         string
+	placeholder string
+	field       func(gs *database.GuildSettings) *string
+}{
+	{"role", "Канал панели ролей", func(gs *database.GuildSettings) *string { return &gs.RoleChannelID }},
+	{"notification", "Канал уведомлений о продлении", func(gs *database.GuildSettings) *string { return &gs.NotificationChannelID }},
+	{"stats", "Канал статистики", func(gs *database.GuildSettings) *string { return &gs.StatsChannelID }},
+	{"staff", "Канал заявок для модераторов", func(gs *database.GuildSettings) *string { return &gs.StaffChannelID }},
+}
+
+// commands - слэш-команды бота. Мастер настройки по умолчанию виден только
+// тем, кто может управлять сервером и ролями, и недоступен в ЛС.
+func commands() []*discordgo.ApplicationCommand {
+	setupPermissions := int64(discordgo.PermissionManageGuild | discordgo.PermissionManageRoles)
+	dmPermission := false
+	return []*discordgo.ApplicationCommand{
+		{
+			Name:                     setupCommandName,
+			Description:              "Настроить каналы, роли и сроки бота на этом сервере",
+			DefaultMemberPermissions: &setupPermissions,
+			DMPermission:             &dmPermission,
+		},
+	}
+}
+
+// registerCommands публикует слэш-команды бота глобально, заменяя прежний набор
+func registerCommands(ctx context.Context, s *discordgo.Session, appID string) {
+	if _, err := s.ApplicationCommandBulkOverwrite(appID, "", commands(), discordgo.WithContext(ctx)); err != nil {
+		slog.ErrorContext(ctx, "Error registering slash commands", logging.Err(err))
+		return
+	}
+	slog.InfoContext(ctx, "Slash commands registered")
+}
+
+// canConfigure проверяет, что пользователь может менять настройки сервера: мастер
+// меняет и каналы, и роли, которые участники выдают себе сами, поэтому нужны права
+// управления сервером и ролями либо администратора. Права команды видны в клиенте,
+// но проверка на стороне бота обязательна.
+func canConfigure(i *discordgo.InteractionCreate) bool {
+	if i.Member == nil {
+		return false
+	}
+	const needed = discordgo.PermissionManageGuild | discordgo.PermissionManageRoles
+	perms := i.Member.Permissions
+	return perms&discordgo.PermissionAdministrator != 0 || perms&needed == needed
+}
+
+// adminOnly пропускает к обработчику мастера только тех, кто может менять настройки сервера
+func adminOnly(h HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request) {
+		if !canConfigure(req.Interaction) {
+			req.Responder.Reply("Настраивать бота могут только участники с правами управления сервером и ролями.")
+			return
+		}
+		h(ctx, req)
+	}
+}
+
+// setupWizard - общее для всех шагов мастера: реестр серверов и то, что нужно для
+// применения настроек без перезапуска
+type setupWizard struct {
+	registry     *guilds.Registry
+	codec        *customid.Codec
+	refreshStats func()
+}
+
+// handleSetup открывает мастер настройки: текущие значения и меню для их изменения
+func (w *setupWizard) handleSetup(r *Responder, cfg *config.Config) {
+	components, err := w.setupComponents(cfg)
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии настроек")
+		return
+	}
+	r.ReplyWithComponents(setupSummary(cfg), components)
+}
+
+func (w *setupWizard) setupComponents(cfg *config.Config) ([]discordgo.MessageComponent, error) {
+	current := map[string]string{
+		"role":         cfg.RoleChannelID,
+		"notification": cfg.NotificationChannelID,
+		"stats":        cfg.StatsChannelID,
+		"staff":        cfg.StaffChannelID,
+	}
+
+	zero := 0
+	rows := make([]discordgo.MessageComponent, 0, len(setupChannels)+1)
+	for _, channel := range setupChannels {
+		menuID, err := w.codec.Encode(customid.ActionSetupChannel, channel.key)
+		if err != nil {
+			return nil, err
+		}
+		menu := discordgo.SelectMenu{
+			MenuType:     discordgo.ChannelSelectMenu,
+			CustomID:     menuID,
+			Placeholder:  channel.placeholder,
+			MinValues:    &zero,
+			MaxValues:    1,
+			ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+		}
+		if id := current[channel.key]; id != "" {
+			menu.DefaultValues = []discordgo.SelectMenuDefaultValue{{ID: id, Type: discordgo.SelectMenuDefaultValueChannel}}
+		}
+		rows = append(rows, discordgo.ActionsRow{Components: []discordgo.MessageComponent{menu}})
+	}
+
+	buttons := []discordgo.Button{
+		{Label: "Роли", Style: discordgo.PrimaryButton},
+		{Label: "Сроки", Style: discordgo.SecondaryButton},
+		{Label: "Каталог (JSON)", Style: discordgo.SecondaryButton},
+	}
+	actions := []string{customid.ActionSetupRoles, customid.ActionSetupDurations, customid.ActionSetupCatalog}
+	controls := make([]discordgo.MessageComponent, 0, len(buttons))
+	for i, button := range buttons {
+		id, err := w.codec.Encode(actions[i], "")
+		if err != nil {
+			return nil, err
+		}
+		button.CustomID = id
+		controls = append(controls, button)
+	}
+	rows = append(rows, discordgo.ActionsRow{Components: controls})
+	return rows, nil
+}
+
+// setupSummary - текущие настройки сервера в тексте мастера
+func setupSummary(cfg *config.Config) string {
+	channel := func(id string) string {
+		if id == "" {
+			return "не задан"
+		}
+		return "<#" + id + ">"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("**⚙️ Настройка бота**\n")
+	sb.WriteString(fmt.Sprintf("Панель ролей: %s\n", channel(cfg.RoleChannelID)))
+	sb.WriteString(fmt.Sprintf("Уведомления: %s\n", channel(cfg.NotificationChannelID)))
+	sb.WriteString(fmt.Sprintf("Статистика: %s\n", channel(cfg.StatsChannelID)))
+	sb.WriteString(fmt.Sprintf("Заявки: %s\n", channel(cfg.StaffChannelID)))
+
+	if cfg.InactiveRoleID != "" {
+		sb.WriteString(fmt.Sprintf("Метка неактивности: <@&%s>\n", cfg.InactiveRoleID))
+	} else {
+		sb.WriteString("Метка неактивности: не задана\n")
+	}
+
+	if len(cfg.Roles) == 0 {
+		sb.WriteString("Роли на панели: нет\n")
+	} else {
+		mentions := make([]string, 0, len(cfg.Roles))
+		for _, role := range cfg.Roles {
+			mentions = append(mentions, "<@&"+role.ID+">")
+		}
+		sb.WriteString(fmt.Sprintf("Роли на панели: %s\n", strings.Join(mentions, ", ")))
+	}
+
+	sb.WriteString(fmt.Sprintf("Срок роли: %s, время на ответ о продлении: %s\n",
+		cfg.RoleDuration, cfg.RenewalDuration))
+	sb.WriteString("\nИзменения применяются сразу, без перезапуска бота.")
+	return sb.String()
+}
+
+// update меняет настройки сервера функцией change, сохраняет их и применяет
+func (w *setupWizard) update(ctx context.Context, s *discordgo.Session, guildID string, change func(gs *database.GuildSettings)) (*config.Config, error) {
+	old, updated, err := w.registry.Update(ctx, guildID, change)
+	if err != nil {
+		return nil, err
+	}
+	w.apply(ctx, s, old, updated)
+	return updated, nil
+}
+
+// apply переносит то, что бот уже опубликовал на сервере, под новые настройки.
+// Канал уведомлений и остальные значения читаются из реестра при каждом
+// использовании и отдельного применения не требуют.
+func (w *setupWizard) apply(ctx context.Context, s *discordgo.Session, old, updated *config.Config) {
+	RefreshRoleSelectionMessage(ctx, s, old, updated, w.codec)
+
+	if old.StatsChannelID != updated.StatsChannelID {
+		w.refreshStats()
+	}
+}
+
+// handleSetupChannel сохраняет канал, выбранный в меню мастера
+func (w *setupWizard) handleSetupChannel(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, id customid.ID) {
+	var field func(gs *database.GuildSettings) *string
+	var name string
+	for _, channel := range setupChannels {
+		if channel.key == id.Arg {
+			field, name = channel.field, channel.placeholder
+		}
+	}
+	if field == nil {
+		r.Reply("Ошибка обработки запроса: неизвестный канал")
+		return
+	}
+
+	var channelID string
+	if values := i.MessageComponentData().Values; len(values) > 0 {
+		channelID = values[0]
+	}
+	if channelID != "" {
+		if err := checkBotCanSend(ctx, s, channelID); err != nil {
+			respondError(r, err, "Ошибка при проверке прав бота")
+			return
+		}
+	}
+
+	_, err := w.update(ctx, s, i.GuildID, func(gs *database.GuildSettings) {
+		*field(gs) = channelID
+	})
+	if err != nil {
+		respondError(r, err, "Ошибка при сохранении настроек")
+		return
+	}
+
+	if channelID == "" {
+		r.Reply(fmt.Sprintf("✅ %s: сброшен к значению по умолчанию.", name))
+		return
+	}
+	r.Reply(fmt.Sprintf("✅ %s: <#%s>.", name, channelID))
+}
+
+// handleSetupRoles показывает выбор ролей панели и роли-метки неактивности
+func (w *setupWizard) handleSetupRoles(r *Responder, cfg *config.Config) {
+	panelRolesID, err := w.codec.Encode(customid.ActionSetupPanelRoles, "")
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии настроек")
+		return
+	}
+	inactiveRoleID, err := w.codec.Encode(customid.ActionSetupInactiveRole, "")
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии настроек")
+		return
+	}
+
+	zero := 0
+	panelRoles := discordgo.SelectMenu{
+		MenuType:    discordgo.RoleSelectMenu,
+		CustomID:    panelRolesID,
+		Placeholder: "Роли на панели",
+		MinValues:   &zero,
+		MaxValues:   config.MaxRoles,
+	}
+	for _, role := range cfg.Roles {
+		panelRoles.DefaultValues = append(panelRoles.DefaultValues,
+			discordgo.SelectMenuDefaultValue{ID: role.ID, Type: discordgo.SelectMenuDefaultValueRole})
+	}
+
+	inactiveRole := discordgo.SelectMenu{
+		MenuType:    discordgo.RoleSelectMenu,
+		CustomID:    inactiveRoleID,
+		Placeholder: "Роль-метка на время льготного периода",
+		MinValues:   &zero,
+		MaxValues:   1,
+	}
+	if cfg.InactiveRoleID != "" {
+		inactiveRole.DefaultValues = []discordgo.SelectMenuDefaultValue{{ID: cfg.InactiveRoleID, Type: discordgo.SelectMenuDefaultValueRole}}
+	}
+
+	r.ReplyWithComponents(
+		"Выберите роли, которые можно получить на панели. У новых ролей будут настройки по умолчанию - "+
+			"ограничения и автопродление задаются в каталоге (JSON).",
+		[]discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{panelRoles}},
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{inactiveRole}},
+		})
+}
+
+// handleSetupPanelRoles пересобирает каталог по выбранным ролям: у ролей, которые
+// уже были в каталоге, настройки сохраняются, новые добавляются в конец
+func (w *setupWizard) handleSetupPanelRoles(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, cfg *config.Config) {
+	data := i.MessageComponentData()
+	selected := make(map[string]bool, len(data.Values))
+	var added []string
+	for _, roleID := range data.Values {
+		selected[roleID] = true
+		if _, ok := cfg.RoleByID(roleID); !ok {
+			added = append(added, roleID)
+		}
+	}
+	if err := w.checkAssignable(ctx, s, i, added); err != nil {
+		respondError(r, err, "Ошибка при проверке ролей")
+		return
+	}
+
+	roles := make([]config.RoleDefinition, 0, len(data.Values))
+	nextKey := 1
+	for _, role := range cfg.Roles {
+		if key, err := strconv.Atoi(role.Key); err == nil && key >= nextKey {
+			nextKey = key + 1
+		}
+		if selected[role.ID] {
+			roles = append(roles, role)
+			delete(selected, role.ID)
+		}
+	}
+	for _, roleID := range data.Values {
+		if !selected[roleID] {
+			continue
+		}
+		name := roleID
+		if role := data.Resolved.Roles[roleID]; role != nil {
+			name = role.Name
+		}
+		roles = append(roles, config.RoleDefinition{Key: strconv.Itoa(nextKey), ID: roleID, Name: name, Style: "primary"})
+		nextKey++
+	}
+
+	catalog, err := json.Marshal(roles)
+	if err != nil {
+		respondError(r, err, "Ошибка при сохранении настроек")
+		return
+	}
+
+	updated, err := w.update(ctx, s, i.GuildID, func(gs *database.GuildSettings) {
+		gs.RoleCatalog = catalog
+	})
+	if err != nil {
+		respondError(r, err, "Ошибка при сохранении настроек")
+		return
+	}
+
+	if len(updated.Roles) == 0 {
+		r.Reply("✅ Роли убраны с панели, панель снята.")
+		return
+	}
+	r.Reply(fmt.Sprintf("✅ Ролей на панели: %d, панель обновлена.", len(updated.Roles)))
+}
+
+// handleSetupInactiveRole сохраняет роль-метку льготного периода
+func (w *setupWizard) handleSetupInactiveRole(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder) {
+	var roleID string
+	if values := i.MessageComponentData().Values; len(values) > 0 {
+		roleID = values[0]
+	}
+	// Метку выдаёт бот, так что к ней те же требования, что и к ролям панели
+	if roleID != "" {
+		if err := w.checkAssignable(ctx, s, i, []string{roleID}); err != nil {
+			respondError(r, err, "Ошибка при проверке ролей")
+			return
+		}
+	}
+
+	_, err := w.update(ctx, s, i.GuildID, func(gs *database.GuildSettings) {
+		gs.InactiveRoleID = roleID
+	})
+	if err != nil {
+		respondError(r, err, "Ошибка при сохранении настроек")
+		return
+	}
+
+	if roleID == "" {
+		r.Reply("✅ Метка неактивности сброшена к значению по умолчанию.")
+		return
+	}
+	r.Reply(fmt.Sprintf("✅ Метка неактивности: <@&%s>.", roleID))
+}
+
+// checkAssignable проверяет, что настраивающий может отдать роли roleIDs под управление бота
+func (w *setupWizard) checkAssignable(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, roleIDs []string) error {
+	if len(roleIDs) == 0 {
+		return nil
+	}
+	h, err := loadRoleHierarchy(ctx, s, i.GuildID)
+	if err != nil {
+		return err
+	}
+	for _, roleID := range roleIDs {
+		if err := h.checkAssignable(i.Member, roleID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleSetupDurations открывает модальное окно сроков.
+// Пустое поле - значение по умолчанию из окружения.
+func (w *setupWizard) handleSetupDurations(i *discordgo.InteractionCreate, r *Responder) {
+	gs, _ := w.registry.Settings(i.GuildID)
+	base := w.registry.Base()
+
+	input := func(id, label, value, placeholder string) discordgo.MessageComponent {
+		return discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:    id,
+					Label:       label,
+					Style:       discordgo.TextInputShort,
+					Value:       value,
+					Placeholder: placeholder,
+					Required:    false,
+					MaxLength:   20,
+				},
+			},
+		}
+	}
+
+	modalID, err := w.codec.Encode(customid.ActionSetupDurationsForm, "")
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии формы")
+		return
+	}
+
+	err = r.Modal(modalID, "Сроки", []discordgo.MessageComponent{
+		input(setupRoleDurationInput, "Срок роли (например, 65h или 7d)", gs.RoleDuration, "По умолчанию "+base.RoleDuration.String()),
+		input(setupRenewalDurationInput, "Время на ответ о продлении (например, 10h)", gs.RenewalDuration, "По умолчанию "+base.RenewalDuration.String()),
+	})
+	if err != nil {
+		slog.ErrorContext(r.ctx, "Error opening setup durations modal", logging.Err(err))
+	}
+}
+
+func (w *setupWizard) handleSetupDurationsForm(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder) {
+	roleDuration := strings.TrimSpace(modalTextValue(i, setupRoleDurationInput))
+	renewalDuration := strings.TrimSpace(modalTextValue(i, setupRenewalDurationInput))
+
+	for _, value := range []string{roleDuration, renewalDuration} {
+		if value == "" {
+			continue
+		}
+		if d, err := config.ParseDuration(value); err != nil || d <= 0 {
+			r.Reply(fmt.Sprintf("Не удалось разобрать срок %q. Используйте формат вроде 65h, 90m или 7d.", value))
+			return
+		}
+	}
+
+	updated, err := w.update(ctx, s, i.GuildID, func(gs *database.GuildSettings) {
+		gs.RoleDuration = roleDuration
+		gs.RenewalDuration = renewalDuration
+	})
+	if err != nil {
+		respondError(r, err, "Ошибка при сохранении настроек")
+		return
+	}
+
+	r.Reply(fmt.Sprintf("✅ Срок роли: %s, время на ответ о продлении: %s. Новые сроки действуют для следующих выдач и продлений.",
+		updated.RoleDuration, updated.RenewalDuration))
+}
+
+// handleSetupCatalog открывает модальное окно с каталогом ролей в JSON - там
+// настраивается то, чего нет в меню: ограничения, очередь, одобрение, автопродление
+func (w *setupWizard) handleSetupCatalog(r *Responder, cfg *config.Config) {
+	current, err := json.MarshalIndent(cfg.Roles, "", "  ")
+	if err != nil {
+		respondError(r, err, "Ошибка при чтении каталога")
+		return
+	}
+	if len(cfg.Roles) == 0 {
+		current = []byte("[]")
+	}
+	if len([]rune(string(current))) > maxCatalogInput {
+		r.Reply("Каталог слишком большой для редактирования в Discord. Уменьшите число ролей через кнопку «Роли».")
+		return
+	}
+
+	modalID, err := w.codec.Encode(customid.ActionSetupCatalogForm, "")
+	if err != nil {
+		respondError(r, err, "Ошибка при открытии формы")
+		return
+	}
+
+	err = r.Modal(modalID, "Каталог ролей", []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.TextInput{
+					CustomID:  setupCatalogInput,
+					Label:     "Каталог ролей в формате ROLE_CATALOG_FILE",
+					Style:     discordgo.TextInputParagraph,
+					Value:     string(current),
+					Required:  true,
+					MaxLength: maxCatalogInput,
+				},
+			},
+		},
+	})
+	if err != nil {
+		slog.ErrorContext(r.ctx, "Error opening setup catalog modal", logging.Err(err))
+	}
+}
+
+func (w *setupWizard) handleSetupCatalogForm(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate, r *Responder, cfg *config.Config) {
+	raw := []byte(strings.TrimSpace(modalTextValue(i, setupCatalogInput)))
+	roles, err := config.ParseRoleCatalog(raw, "from /setup")
+	if err != nil {
+		r.Reply(fmt.Sprintf("Каталог не сохранён: %v", err))
+		return
+	}
+
+	// Проверяем новые роли и те, у которых изменились правила: настройки ролей,
+	// добавленных кем-то с более высокой ролью, остаются как были
+	var changed []string
+	for _, role := range roles {
+		if current, ok := cfg.RoleByID(role.ID); !ok || !reflect.DeepEqual(current, role) {
+			changed = append(changed, role.ID)
+		}
+	}
+	if err := w.checkAssignable(ctx, s, i, changed); err != nil {
+		respondError(r, err, "Ошибка при проверке ролей")
+		return
+	}
+
+	// Храним каталог в компактном виде, проверенным
+	catalog, err := json.Marshal(roles)
+	if err != nil {
+		respondError(r, err, "Ошибка при сохранении настроек")
+		return
+	}
+
+	_, err = w.update(ctx, s, i.GuildID, func(gs *database.GuildSettings) {
+		gs.RoleCatalog = catalog
+	})
+	if err != nil {
+		respondError(r, err, "Ошибка при сохранении настроек")
+		return
+	}
+
+	r.Reply(fmt.Sprintf("✅ Каталог сохранён, ролей на панели: %d.", len(roles)))
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"neble_2/database"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// voiceTimeout ограничивает запись одного голосового события
+const voiceTimeout = 5 * time.Second
+
+// VoiceTracker записывает голосовые сессии участников и относит их к активной роли
+type VoiceTracker struct {
+	db     *database.DB
+	guilds *guilds.Registry
+}
+
+func NewVoiceTracker(db *database.DB, registry *guilds.Registry) *VoiceTracker {
+	return &VoiceTracker{db: db, guilds: registry}
+}
+
+// VoiceStateUpdate открывает сессию при входе в голосовой канал и закрывает при выходе.
+// Переход между каналами сессию не прерывает.
+func (t *VoiceTracker) VoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if _, ok := t.guilds.Get(v.GuildID); !ok {
+		return
+	}
+	if v.Member != nil && v.Member.User != nil && v.Member.User.Bot {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), voiceTimeout)
+	defer cancel()
+	ctx = logging.WithCorrelationID(logging.With(ctx, logging.KeyGuildID, v.GuildID, logging.KeyUserID, v.UserID))
+
+	if v.ChannelID == "" {
+		if err := t.db.CloseVoiceSession(ctx, v.GuildID, v.UserID); err != nil {
+			slog.ErrorContext(ctx, "Error closing voice session", logging.Err(err))
+		}
+		return
+	}
+
+	userName := v.UserID
+	if v.Member != nil && v.Member.User != nil {
+		userName = v.Member.User.Username
+	}
+	t.open(ctx, v.GuildID, v.UserID, userName)
+}
+
+// GuildCreate сверяет открытые сессии с голосовыми каналами после (пере)подключения.
+// Кто вышел из голоса, пока бот был недоступен, получает сессию, закрытую сейчас.
+func (t *VoiceTracker) GuildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	if _, ok := t.guilds.Get(g.ID); !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx = logging.WithCorrelationID(logging.With(ctx, logging.KeyGuildID, g.ID))
+
+	inVoice := make([]string, 0, len(g.VoiceStates))
+	for _, state := range g.VoiceStates {
+		if state.ChannelID == "" {
+			continue
+		}
+		userName := state.UserID
+		if state.Member != nil && state.Member.User != nil {
+			if state.Member.User.Bot {
+				continue
+			}
+			userName = state.Member.User.Username
+		}
+
+		inVoice = append(inVoice, state.UserID)
+		t.open(ctx, g.ID, state.UserID, userName)
+	}
+
+	closed, err := t.db.CloseVoiceSessionsExcept(ctx, g.ID, inVoice)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error closing stale voice sessions", logging.Err(err))
+		return
+	}
+	if closed > 0 {
+		slog.InfoContext(ctx, "Closed voice sessions left open while disconnected", "count", closed)
+	}
+}
+
+func (t *VoiceTracker) open(ctx context.Context, guildID, userID, userName string) {
+	var roleID, roleName string
+	role, err := t.db.GetActiveRoleByUserID(ctx, guildID, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting active role", logging.UserID(userID), logging.Err(err))
+	} else if role != nil {
+		roleID, roleName = role.RoleID, role.RoleName
+	}
+
+	if err := t.db.OpenVoiceSession(ctx, guildID, userID, userName, roleID, roleName); err != nil {
+		slog.ErrorContext(ctx, "Error opening voice session", logging.UserID(userID), logging.Err(err))
+	}
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/customid"
+	"neble_2/database"
+	"neble_2/eligibility"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"neble_2/scheduler"
+	"neble_2/webhooks"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// ErrRoleFull - все места роли заняты; пользователю предлагается очередь
+var ErrRoleFull = errors.New("role is full")
+
+// waitlistTimeout ограничивает обработку одного освободившегося места
+const waitlistTimeout = 30 * time.Second
+
+// checkCapacity проверяет, есть ли у роли свободное место для пользователя.
+// Вызывается внутри транзакции выдачи роли: блокировка держится до коммита.
+func checkCapacity(ctx context.Context, tx *database.DB, cfg *config.Config, role config.RoleDefinition, userID string) error {
+	if role.MaxHolders <= 0 {
+		return nil
+	}
+
+	if err := tx.LockRoleCapacity(ctx, role.ID); err != nil {
+		return fmt.Errorf("lock role capacity: %w", err)
+	}
+
+	holders, err := tx.CountActiveHolders(ctx, role.ID)
+	if err != nil {
+		return fmt.Errorf("count role holders: %w", err)
+	}
+
+	reserved, err := tx.CountReservations(ctx, role.ID, userID, cfg.WaitlistReservation)
+	if err != nil {
+		return fmt.Errorf("count waitlist reservations: %w", err)
+	}
+
+	if holders+reserved >= role.MaxHolders {
+		return ErrRoleFull
+	}
+	return nil
+}
+
+// replyRoleFull предлагает встать в очередь на заполненную роль
+func replyRoleFull(r *Responder, codec *customid.Codec, role config.RoleDefinition) {
+	joinID, err := codec.Encode(customid.ActionJoinWaitlist, role.Key)
+	if err != nil {
+		// Без кнопки очереди хотя бы сообщаем, что мест нет
+		slog.ErrorContext(r.ctx, "Error encoding waitlist button", logging.Err(err))
+		r.Reply(fmt.Sprintf("Все места в роли **%s** заняты (%d из %d).", role.Name, role.MaxHolders, role.MaxHolders))
+		return
+	}
+	r.ReplyWithComponents(
+		fmt.Sprintf("Все места в роли **%s** заняты (%d из %d). Можно встать в очередь - мы сообщим, когда место освободится.",
+			role.Name, role.MaxHolders, role.MaxHolders),
+		[]discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Встать в очередь",
+						Style:    discordgo.PrimaryButton,
+						CustomID: joinID,
+					},
+				},
+			},
+		},
+	)
+}
+
+func handleJoinWaitlist(ctx context.Context, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config, roleKey string) {
+	user := interactionUser(i)
+
+	role, exists := cfg.RoleByKey(roleKey)
+	if !exists || role.MaxHolders <= 0 {
+		r.Reply("Для этой роли очередь не ведётся")
+		return
+	}
+
+	position, err := db.JoinWaitlist(ctx, user.ID, user.Username, role.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error joining waitlist", "role_id", role.ID, logging.Err(err))
+		r.ReplyFailure("Ошибка при постановке в очередь")
+		return
+	}
+
+	r.Reply(fmt.Sprintf("Вы в очереди на роль **%s**, ваше место: **%d**.", role.Name, position))
+}
+
+func handleWaitlistStatus(ctx context.Context, i *discordgo.InteractionCreate, r *Responder, db *database.DB, cfg *config.Config) {
+	entries, err := db.GetWaitlistPositions(ctx, interactionUser(i).ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting waitlist positions", logging.Err(err))
+		r.ReplyFailure("Ошибка при получении очереди")
+		return
+	}
+
+	if len(entries) == 0 {
+		r.Reply("Вы не стоите в очереди ни на одну роль.")
+		return
+	}
+
+	// Очереди других серверов здесь не показываем
+	var sb strings.Builder
+	for _, entry := range entries {
+		role, ok := cfg.RoleByID(entry.RoleID)
+		if !ok {
+			continue
+		}
+		name := role.Name
+		if entry.NotifiedAt.Valid {
+			sb.WriteString(fmt.Sprintf("- **%s**: место придержано для вас до %s\n",
+				name, entry.NotifiedAt.Time.Add(cfg.WaitlistReservation).Format("02.01.2006 15:04")))
+		} else {
+			sb.WriteString(fmt.Sprintf("- **%s**: место %d\n", name, entry.Position))
+		}
+	}
+	if sb.Len() == 0 {
+		r.Reply("Вы не стоите в очереди ни на одну роль.")
+		return
+	}
+	r.Reply("Ваши очереди:\n" + sb.String())
+}
+
+// Waitlist раздаёт освободившиеся места ролей пользователям из очереди
+type Waitlist struct {
+	session *discordgo.Session
+	db      *database.DB
+	guilds  *guilds.Registry
+}
+
+func NewWaitlist(s *discordgo.Session, db *database.DB, registry *guilds.Registry) *Waitlist {
+	return &Waitlist{session: s, db: db, guilds: registry}
+}
+
+// SlotFreed вызывается базой, когда у роли освобождается место.
+// Сервер определяется по каталогу, в котором описана роль.
+func (w *Waitlist) SlotFreed(ctx context.Context, roleID string) {
+	cfg, role, ok := w.guilds.ByRoleID(roleID)
+	if !ok || role.MaxHolders <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, waitlistTimeout)
+	defer cancel()
+	ctx = logging.With(ctx, logging.KeyGuildID, cfg.GuildID, "role_id", role.ID)
+
+	if role.AutoAssignWaitlist {
+		w.autoAssign(ctx, cfg, role)
+	} else {
+		w.notifyNext(ctx, cfg, role)
+	}
+}
+
+// notifyNext придерживает место за первым в очереди и сообщает ему об этом
+func (w *Waitlist) notifyNext(ctx context.Context, cfg *config.Config, role config.RoleDefinition) {
+	var next *database.WaitlistEntry
+	err := w.db.WithTx(ctx, func(tx *database.DB) error {
+		if err := checkCapacity(ctx, tx, cfg, role, ""); err != nil {
+			return err
+		}
+
+		entry, err := tx.NextWaitlistEntry(ctx, role.ID)
+		if err != nil || entry == nil {
+			return err
+		}
+		if err := tx.MarkWaitlistNotified(ctx, entry.ID); err != nil {
+			return err
+		}
+		next = entry
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, ErrRoleFull) {
+			slog.ErrorContext(ctx, "Error processing waitlist", logging.Err(err))
+		}
+		return
+	}
+	if next == nil {
+		return
+	}
+
+	slog.InfoContext(ctx, "Reserved a slot for waitlisted user", logging.UserID(next.UserID))
+	notifyUser(ctx, w.session, cfg, next.UserID, fmt.Sprintf(
+		"Освободилось место в роли **%s**! Оно придержано для вас до %s - выберите роль на панели.",
+		role.Name, time.Now().Add(cfg.WaitlistReservation).Format("02.01.2006 15:04")))
+}
+
+// autoAssign выдаёт роль пользователям из очереди, пока есть свободные места.
+// Каждое место разыгрывается в своей транзакции: проверка мест, выбор первого
+// в очереди и выдача роли в базе идут под одной блокировкой, а роль в Discord
+// выдаётся после коммита.
+func (w *Waitlist) autoAssign(ctx context.Context, cfg *config.Config, role config.RoleDefinition) {
+	for {
+		var entry *database.WaitlistEntry
+		var assigned *database.UserRole
+		err := w.db.WithTx(ctx, func(tx *database.DB) error {
+			// Сначала места: пока роль заполнена, очередь не трогаем и в Discord не ходим
+			if err := checkCapacity(ctx, tx, cfg, role, ""); err != nil {
+				return err
+			}
+
+			var err error
+			entry, err = tx.NextWaitlistEntry(ctx, role.ID)
+			if err != nil || entry == nil {
+				return err
+			}
+
+			// Пропускаем тех, кто покинул сервер или больше не подходит. Прочие ошибки
+			// Discord временные: запись остаётся, очередь обработается в следующий раз.
+			member, err := w.session.GuildMember(cfg.GuildID, entry.UserID, discordgo.WithContext(ctx))
+			if err != nil && !scheduler.IsDiscordError(err, discordgo.ErrCodeUnknownMember) {
+				return fmt.Errorf("get waitlisted member %s: %w", entry.UserID, err)
+			}
+			if err == nil {
+				err = eligibility.Check(member, role, 0, time.Now())
+			}
+			if err != nil {
+				slog.InfoContext(ctx, "Skipping waitlisted user", logging.UserID(entry.UserID), logging.Err(err))
+				return tx.RemoveWaitlistEntry(ctx, entry.ID)
+			}
+
+			assigned, err = tx.AssignRole(ctx, cfg.GuildID, entry.UserID, entry.UserName, role.ID, role.Name, time.Now().Add(cfg.RoleDuration))
+			if err != nil {
+				return err
+			}
+			return webhooks.Publish(ctx, tx, cfg, metrics.EventGranted, assigned)
+		})
+		switch {
+		case errors.Is(err, ErrRoleFull):
+			return
+		case errors.Is(err, database.ErrActiveRoleExists):
+			// Транзакция уже откатилась - запись очереди удаляется отдельно
+			slog.InfoContext(ctx, "Skipping waitlisted user: already has an active role", logging.UserID(entry.UserID))
+			if !w.skipEntry(ctx, entry) {
+				return
+			}
+			continue
+		case err != nil:
+			slog.ErrorContext(ctx, "Error auto-assigning role from waitlist", logging.Err(err))
+			return
+		case entry == nil:
+			return // очередь пуста
+		case assigned == nil:
+			continue // пользователь пропущен
+		}
+
+		// При ошибке Discord запись удаляется, а пользователь остаётся в очереди до следующего раза
+		if err := grantAssignedRole(ctx, w.session, w.db, cfg, assigned, nil); err != nil {
+			slog.ErrorContext(ctx, "Error auto-assigning role from waitlist", logging.Err(err))
+			return
+		}
+
+		slog.InfoContext(ctx, "Role auto-assigned to waitlisted user", logging.UserID(assigned.UserID))
+		metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.ID, metrics.EventGranted).Inc()
+		notifyUser(ctx, w.session, cfg, assigned.UserID, fmt.Sprintf(
+			"Освободилось место в роли **%s** - роль выдана вам автоматически!", role.Name))
+	}
+}
+
+// skipEntry убирает пропущенного пользователя из очереди. Возвращает false, если
+// это не удалось и обработку очереди нужно прервать.
+func (w *Waitlist) skipEntry(ctx context.Context, entry *database.WaitlistEntry) bool {
+	if err := w.db.RemoveWaitlistEntry(ctx, entry.ID); err != nil {
+		slog.ErrorContext(ctx, "Error removing waitlist entry", logging.UserID(entry.UserID), logging.Err(err))
+		return false
+	}
+	return true
+}
+
+// notifyUser пишет пользователю в ЛС, а если ЛС закрыты - в канал уведомлений с упоминанием
+func notifyUser(ctx context.Context, s *discordgo.Session, cfg *config.Config, userID, content string) {
+	dm, err := s.UserChannelCreate(userID, discordgo.WithContext(ctx))
+	if err == nil {
+		if _, err = s.ChannelMessageSend(dm.ID, content, discordgo.WithContext(ctx)); err == nil {
+			return
+		}
+	}
+	slog.WarnContext(ctx, "Could not DM user, falling back to notification channel", logging.UserID(userID), logging.Err(err))
+
+	if _, err := s.ChannelMessageSend(cfg.NotificationChannelID, fmt.Sprintf("<@%s>, %s", userID, content), discordgo.WithContext(ctx)); err != nil {
+		slog.ErrorContext(ctx, "Error sending notification", logging.UserID(userID), logging.Err(err))
+	}
+}
@@ -0,0 +1,158 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"neble_2/database"
+	"neble_2/logging"
+	"neble_2/scheduler"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// heartbeatTimeout - сколько можно не получать подтверждение heartbeat от шлюза.
+	// Discord присылает его примерно раз в 40 секунд: за это время пропущено несколько подряд,
+	// и переподключение discordgo само не справилось.
+	heartbeatTimeout = 2 * time.Minute
+	// pingTimeout - сколько ждать ответа БД при проверке готовности
+	pingTimeout = 2 * time.Second
+)
+
+// Check - результат одной проверки
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	// Необязательные подробности конкретной проверки
+	LastHeartbeatAck *time.Time `json:"last_heartbeat_ack,omitempty"`
+	LastSuccess      *time.Time `json:"last_success,omitempty"`
+}
+
+// Report - ответ /healthz и /readyz
+type Report struct {
+	Status string           `json:"status"` // "ok" или "fail"
+	Checks map[string]Check `json:"checks"`
+}
+
+// Checker проверяет состояние бота для оркестратора контейнеров:
+// /healthz (liveness) - жив ли процесс, /readyz (readiness) - может ли он работать
+type Checker struct {
+	session   *discordgo.Session
+	db        *database.DB
+	scheduler *scheduler.Scheduler
+}
+
+func NewChecker(s *discordgo.Session, db *database.DB, sched *scheduler.Scheduler) *Checker {
+	return &Checker{session: s, db: db, scheduler: sched}
+}
+
+// Register добавляет /healthz и /readyz в mux
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Live())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Ready(r.Context()))
+	})
+}
+
+// Live проверяет только то, что исправляется перезапуском: соединение со шлюзом
+// давно не подаёт признаков жизни. Кратковременные переподключения сюда не попадают.
+func (c *Checker) Live() Report {
+	return newReport(map[string]Check{"heartbeat": c.heartbeat(time.Now())})
+}
+
+// Ready проверяет всё, без чего бот не обслуживает пользователей:
+// соединение со шлюзом, БД и своевременные запуски задач планировщика
+func (c *Checker) Ready(ctx context.Context) Report {
+	now := time.Now()
+	checks := map[string]Check{
+		"gateway":   c.gateway(),
+		"heartbeat": c.heartbeat(now),
+		"database":  c.database(ctx),
+	}
+	for _, job := range c.scheduler.Status() {
+		check := Check{OK: !job.Stale(now)}
+		if !job.LastSuccess.IsZero() {
+			check.LastSuccess = &job.LastSuccess
+		}
+		if !check.OK {
+			check.Detail = "no successful run since " + job.Due.Format(time.RFC3339)
+		}
+		checks["scheduler:"+job.Name] = check
+	}
+	return newReport(checks)
+}
+
+// gateway - установлено ли соединение со шлюзом (между READY и разрывом)
+func (c *Checker) gateway() Check {
+	c.session.RLock()
+	ready := c.session.DataReady
+	c.session.RUnlock()
+
+	if !ready {
+		return Check{OK: false, Detail: "gateway is not connected"}
+	}
+	return Check{OK: true}
+}
+
+func (c *Checker) heartbeat(now time.Time) Check {
+	c.session.RLock()
+	ack := c.session.LastHeartbeatAck
+	c.session.RUnlock()
+
+	check := Check{OK: now.Sub(ack) <= heartbeatTimeout}
+	if !ack.IsZero() {
+		check.LastHeartbeatAck = &ack
+	}
+	if !check.OK {
+		check.Detail = "no heartbeat ack from the gateway"
+	}
+	return check
+}
+
+func (c *Checker) database(ctx context.Context) Check {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := c.db.PingContext(ctx); err != nil {
+		return Check{OK: false, Detail: err.Error()}
+	}
+	return Check{OK: true}
+}
+
+func newReport(checks map[string]Check) Report {
+	report := Report{Status: "ok", Checks: checks}
+	for _, check := range checks {
+		if !check.OK {
+			report.Status = "fail"
+		}
+	}
+	return report
+}
+
+// writeReport отвечает 200, если все проверки прошли, и 503 - если нет
+func writeReport(w http.ResponseWriter, report Report) {
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+		var failed []string
+		for name, check := range report.Checks {
+			if !check.OK {
+				failed = append(failed, name+": "+check.Detail)
+			}
+		}
+		sort.Strings(failed)
+		slog.Warn("Health check failed", "failed", failed)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("Error writing health report", logging.Err(err))
+	}
+}
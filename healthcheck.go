@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"neble_2/config"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runHealthcheck - команда "./bot healthcheck" для HEALTHCHECK в Docker: в образе нет curl,
+// поэтому /healthz запрашивает сам бинарник. Возвращает код завершения процесса.
+func runHealthcheck() int {
+	// HTTP_ADDR может быть задан и в .env, как у основного процесса
+	_ = newEnvLoader().Load()
+
+	addr := config.HTTPAddrFromEnv()
+	if addr == "" {
+		// Служебный сервер выключен - проверять нечего
+		return 0
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid HTTP_ADDR %q: %v\n", addr, err)
+		return 1
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "healthz:", resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+)
+
+// Manager ведёт работу бота от запуска до остановки: выдаёт корневой контекст
+// и учитывает выполняющуюся работу (обработчики взаимодействий, обновления
+// статистики, раздачу мест из очереди), чтобы при остановке её дождаться
+type Manager struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	stopping bool
+	wg       sync.WaitGroup
+}
+
+func New() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{ctx: ctx, cancel: cancel}
+}
+
+// Context возвращает корневой контекст. Он отменяется, только если выполняющаяся
+// работа не уложилась в срок остановки.
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Begin регистрирует начало работы. Возвращает false, если бот уже останавливается
+// и новую работу начинать нельзя; иначе по её завершении нужно вызвать done.
+func (m *Manager) Begin() (done func(), ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stopping {
+		return nil, false
+	}
+	m.wg.Add(1)
+	return m.wg.Done, true
+}
+
+// Go выполняет fn в отдельной горутине как учтённую работу. Во время остановки
+// fn не запускается.
+func (m *Manager) Go(fn func(ctx context.Context)) bool {
+	done, ok := m.Begin()
+	if !ok {
+		return false
+	}
+	go func() {
+		defer done()
+		fn(m.ctx)
+	}()
+	return true
+}
+
+// Stopping сообщает, началась ли остановка
+func (m *Manager) Stopping() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopping
+}
+
+// Stop прекращает приём новой работы; уже начатая продолжается
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopping = true
+}
+
+// Wait ждёт завершения начатой работы. Если ctx истёк раньше, корневой контекст
+// отменяется, чтобы оставшаяся работа прервала запросы к БД и Discord, и
+// возвращается ошибка ctx.
+func (m *Manager) Wait(ctx context.Context) error {
+	m.Stop()
+
+	drained := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		m.cancel()
+		return ctx.Err()
+	}
+}
+
+// Cancel отменяет корневой контекст
+func (m *Manager) Cancel() {
+	m.cancel()
+}
@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Transport логирует исходящие HTTP-запросы (запросы к API Discord) с полями
+// из контекста запроса: каждый запрос виден на уровне DEBUG, ошибки - на WARN
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	started := time.Now()
+	resp, err := base.RoundTrip(req)
+
+	ctx := req.Context()
+	attrs := []any{
+		"method", req.Method,
+		"path", req.URL.Path,
+		"duration_ms", time.Since(started).Milliseconds(),
+	}
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "Discord request failed", append(attrs, Err(err))...)
+	case resp.StatusCode >= 400:
+		slog.WarnContext(ctx, "Discord request returned an error status", append(attrs, "status", resp.StatusCode)...)
+	default:
+		slog.DebugContext(ctx, "Discord request", append(attrs, "status", resp.StatusCode)...)
+	}
+	return resp, err
+}
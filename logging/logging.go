@@ -0,0 +1,135 @@
+// Package logging настраивает структурированные JSON-логи бота и переносит
+// поля запроса (correlation_id, guild_id, user_id, ...) через context.Context:
+// всё, что залогировано с этим контекстом в обработчиках, базе и запросах
+// к Discord, можно найти по одному correlation_id.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Стандартные имена полей, чтобы один и тот же идентификатор не назывался по-разному
+const (
+	KeyCorrelationID = "correlation_id"
+	KeyGuildID       = "guild_id"
+	KeyUserID        = "user_id"
+	KeyAssignmentID  = "assignment_id"
+	KeyInteractionID = "interaction_id"
+	KeyJob           = "job"
+	KeyError         = "error"
+)
+
+// level - текущий уровень логов; меняется на ходу при перезагрузке конфигурации
+var level = new(slog.LevelVar)
+
+// Setup делает JSON-логгер в w логгером по умолчанию. Вызовы стандартного
+// пакета log тоже попадают в него с уровнем INFO.
+func Setup(w io.Writer) {
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+	log.SetFlags(0)
+}
+
+// ParseLevel разбирает уровень логов: debug, info, warn или error
+func ParseLevel(value string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", value)
+	}
+	return l, nil
+}
+
+// SetLevel меняет уровень логов
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Err - поле с ошибкой
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// GuildID - поле с ID сервера
+func GuildID(id string) slog.Attr {
+	return slog.String(KeyGuildID, id)
+}
+
+// UserID - поле с ID пользователя
+func UserID(id string) slog.Attr {
+	return slog.String(KeyUserID, id)
+}
+
+// AssignmentID - поле с ID записи о роли в user_roles
+func AssignmentID(id int) slog.Attr {
+	return slog.Int(KeyAssignmentID, id)
+}
+
+type attrsKey struct{}
+
+// With возвращает контекст, к логам которого добавляются поля args
+// (пары ключ-значение, как у slog.Logger.With). Поле с уже заданным
+// ключом заменяется.
+func With(ctx context.Context, args ...any) context.Context {
+	r := slog.Record{}
+	r.Add(args...)
+	attrs := slices.Clone(contextAttrs(ctx))
+	r.Attrs(func(a slog.Attr) bool {
+		i := slices.IndexFunc(attrs, func(existing slog.Attr) bool { return existing.Key == a.Key })
+		if i >= 0 {
+			attrs[i] = a
+		} else {
+			attrs = append(attrs, a)
+		}
+		return true
+	})
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// WithCorrelationID возвращает контекст с новым correlation_id
+func WithCorrelationID(ctx context.Context) context.Context {
+	return With(ctx, KeyCorrelationID, NewCorrelationID())
+}
+
+// NewCorrelationID генерирует короткий случайный идентификатор
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler добавляет к каждой записи поля из контекста
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := contextAttrs(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -1,21 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"neble_2/adminapi"
 	"neble_2/config"
+	"neble_2/customid"
 	"neble_2/database"
+	"neble_2/guilds"
 	"neble_2/handlers"
+	"neble_2/health"
+	"neble_2/lifecycle"
+	"neble_2/logging"
+	"neble_2/metrics"
 	"neble_2/scheduler"
 	"neble_2/stats"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
-	"github.com/joho/godotenv"
 )
 
+// cleanupTimeout ограничивает уборку сообщений и закрытие соединений при остановке.
+// У уборки свой срок: SHUTDOWN_TIMEOUT к этому моменту мог уйти на ожидание обработчиков.
+const cleanupTimeout = 15 * time.Second
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -23,14 +37,71 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// fatal логирует ошибку запуска и завершает процесс
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
+// startHTTPServer запускает служебный HTTP-сервер: метрики, проверки для оркестратора
+// и админский API (он отвечает, только если задан ADMIN_API_TOKEN)
+func startHTTPServer(addr string, db *database.DB, checker *health.Checker, api *adminapi.Server) *http.Server {
+	metrics.NewGaugeFunc("neble_active_assignments",
+		"Active role assignments by guild and role.",
+		func(ctx context.Context) ([]metrics.Sample, error) {
+			counts, err := db.CountActiveRoles(ctx)
+			if err != nil {
+				return nil, err
+			}
+			samples := make([]metrics.Sample, 0, len(counts))
+			for _, c := range counts {
+				samples = append(samples, metrics.Sample{
+					Labels: []string{c.GuildID, c.RoleID, c.RoleName},
+					Value:  float64(c.Count),
+				})
+			}
+			return samples, nil
+		},
+		"guild_id", "role_id", "role")
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	checker.Register(mux)
+	api.Register(mux)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "addr", addr, logging.Err(err))
+		}
+	}()
+	slog.Info("HTTP server started", "addr", addr)
+	return server
+}
+
 func main() {
-	// Загружаем .env файл
-	err := godotenv.Load()
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(runHealthcheck())
+	}
+
+	// JSON-логи; уровень из конфигурации применяется, как только она прочитана
+	logging.Setup(os.Stderr)
+
+	// Загружаем .env файл; переменные окружения процесса главнее файла
+	env := newEnvLoader()
+	err := env.Load()
 	if err != nil {
-		log.Printf("Warning: .env file not found: %v", err)
+		slog.Warn(".env file not found", logging.Err(err))
 	}
 
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Error loading config", err)
+	}
+	logging.SetLevel(cfg.LogLevel)
 
 	// Используем ваш формат строки подключения
 	dbHost := getEnv("DB_HOST", "localhost")
@@ -45,51 +116,185 @@ func main() {
 	// Создание сессии Discord ПЕРВЫМ
 	discord, err := discordgo.New("Bot " + cfg.Token)
 	if err != nil {
-		log.Fatal("Error creating Discord session:", err)
+		fatal("Error creating Discord session", err)
+	}
+
+	// REST-запросы к Discord попадают в логи с correlation_id вызвавшего их обработчика
+	// и в метрики - без учёта ожидания в общем лимите ниже
+	discord.Client.Transport = &logging.Transport{Base: discord.Client.Transport}
+	discord.Client.Transport = &metrics.Transport{Base: discord.Client.Transport}
+
+	// Все REST-запросы к Discord проходят через общий лимит, чтобы всплески
+	// нажатий и обновлений статистики не упирались в глобальный лимит API
+	if cfg.DiscordRateLimit > 0 {
+		discord.Client.Transport = &handlers.RateLimitedTransport{
+			Base:   discord.Client.Transport,
+			Bucket: handlers.NewTokenBucket(cfg.DiscordRateLimit, cfg.DiscordRateBurst),
+		}
 	}
 
-	// Создаем StatsManager ВТОРЫМ (нужен discord session)
-	statsManager := stats.NewStatsManager(discord, nil, cfg.GuildID, cfg.StatsChannelID) // временно nil для БД
+	// Корневой контекст и учёт выполняющейся работы для плавной остановки
+	life := lifecycle.New()
+
+	// Создаем статистику ВТОРОЙ (нужен discord session)
+	statsHub := stats.NewHub(discord, life)
 
-	// Инициализация БД ТРЕТЬИМ (передаем statsUpdater)
-	db, err := database.New(connStr, statsManager.NotifyUpdate)
+	// Инициализация БД ТРЕТЬЕЙ (передаем statsUpdater)
+	db, err := database.New(connStr, cfg.DBQueryTimeout, statsHub.NotifyUpdate)
 	if err != nil {
-		log.Fatal("Database connection failed:", err)
+		fatal("Database connection failed", err)
 	}
-	defer db.Close()
 
-	// Обновляем StatsManager с реальной БД
-	statsManager.SetDB(db)
+	// Настройки серверов: окружение - значения по умолчанию, поверх них настройки из БД
+	registry := guilds.NewRegistry(cfg, db)
+	if err := registry.Load(context.Background()); err != nil {
+		fatal("Error loading guild settings", err)
+	}
+
+	// Проверяем через API Discord, что каналы и роли существуют и бот может их выдавать,
+	// до того как бот начнёт что-либо публиковать
+	if cfg.StartupCheck {
+		if err := registry.Check(context.Background(), discord); err != nil {
+			fatal("Startup check failed", err)
+		}
+	}
+
+	// Обновляем статистику с реальной БД и списком серверов
+	statsHub.SetDB(db)
+	statsHub.SetGuilds(registry)
+
+	// Освободившиеся места ролей с лимитом раздаются очереди; во время остановки
+	// раздача не начинается - место достанется очереди при следующем освобождении
+	waitlist := handlers.NewWaitlist(discord, db, registry)
+	db.SetRoleFreedHook(func(ctx context.Context, roleID string) {
+		done, ok := life.Begin()
+		if !ok {
+			slog.InfoContext(ctx, "Shutting down, skipping waitlist", "role_id", roleID)
+			return
+		}
+		defer done()
+		waitlist.SlotFreed(ctx, roleID)
+	})
+
+	// Подписанные custom_id кнопок: кнопки со старых или чужих сообщений отклоняются
+	codec := customid.NewCodec(cfg.CustomIDSecret)
 
 	// Добавление обработчиков
 	discord.AddHandler(handlers.Ready)
-	discord.AddHandler(handlers.InteractionCreate(db, cfg))
+	discord.AddHandler(handlers.InteractionCreate(db, registry, codec, life, statsHub.NotifyUpdate))
+
+	// Регистрация серверов, к которым подключён бот
+	discord.AddHandler(handlers.GuildCreate(registry))
+
+	// Активность участников для автопродления ролей
+	activity := handlers.NewActivityTracker(db, registry)
+	discord.AddHandler(activity.MessageCreate)
+	discord.AddHandler(activity.VoiceStateUpdate)
+
+	// Голосовые сессии участников по ролям
+	voice := handlers.NewVoiceTracker(db, registry)
+	discord.AddHandler(voice.VoiceStateUpdate)
+	discord.AddHandler(voice.GuildCreate)
 
 	// Открытие соединения
 	err = discord.Open()
 	if err != nil {
-		log.Fatal("Error opening connection:", err)
+		fatal("Error opening connection", err)
 	}
-	defer discord.Close()
 
-	// Создание сообщения с кнопками для выбора ролей
-	handlers.CreateRoleSelectionMessage(discord, cfg)
-	defer handlers.CleanupRoleMessage(discord, cfg)
-	defer statsManager.CleanupStatsMessage()
+	// Создание сообщений с кнопками для выбора ролей на всех настроенных серверах
+	handlers.CreateRoleSelectionMessages(context.Background(), discord, registry, codec)
 
-	// Запуск планировщика для проверки expired ролей
-	scheduler.StartScheduler(discord, db, cfg)
-	log.Printf("Scheduler started with check interval: 1 hour")
+	// Запуск планировщика задач (проверка expired ролей, таймауты, сверка, статистика)
+	sched, err := scheduler.StartScheduler(life.Context(), discord, db, registry, codec, statsHub.NotifyUpdate)
+	if err != nil {
+		fatal("Error starting scheduler", err)
+	}
+	slog.Info("Scheduler started")
 
 	// Первоначальное создание сообщения со статистикой
-	statsManager.NotifyUpdate()
+	statsHub.NotifyUpdate()
+
+	// Метрики Prometheus и проверки /healthz, /readyz, если задан адрес
+	var server *http.Server
+	if cfg.HTTPAddr != "" {
+		server = startHTTPServer(cfg.HTTPAddr, db,
+			health.NewChecker(discord, db, sched),
+			adminapi.New(discord, db, registry, life))
+	}
+
+	// Перезагрузка конфигурации без перезапуска: по SIGHUP и при изменении файлов
+	reloader := &configReloader{
+		env:          env,
+		session:      discord,
+		registry:     registry,
+		codec:        codec,
+		refreshStats: statsHub.NotifyUpdate,
+	}
+	stopWatch := make(chan struct{})
+	if cfg.ConfigWatchInterval > 0 {
+		go reloader.Watch(cfg.ConfigWatchInterval, stopWatch)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			slog.Info("SIGHUP received, reloading configuration")
+			reloader.Reload()
+		}
+	}()
 
-	log.Println("Bot is now running. Press CTRL-C to exit.")
+	slog.Info("Bot is now running. Press CTRL-C to exit.")
 
 	// Ожидание сигнала завершения
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	<-sc
 
-	log.Println("Shutting down bot...")
+	// Повторный сигнал - не ждать, а выйти сразу
+	go func() {
+		<-sc
+		slog.Warn("Second signal received, exiting immediately")
+		os.Exit(1)
+	}()
+
+	timeout := registry.Base().ShutdownTimeout
+	slog.Info("Shutting down bot", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 1. Новые взаимодействия, обновления статистики и перезагрузки конфигурации больше не начинаются
+	life.Stop()
+	signal.Stop(hup)
+	close(stopWatch)
+
+	// 2. Планировщик не запускает новые задачи и доделывает текущие
+	sched.Stop(ctx)
+
+	// 3. Дожидаемся начатых обработчиков и обновлений статистики; по истечении срока они отменяются
+	if err := life.Wait(ctx); err != nil {
+		slog.Warn("In-flight work did not finish in time, cancelled", logging.Err(err))
+	}
+
+	// 4. Убираем панели ролей и сообщения статистики, пока работает REST
+	cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancelCleanup()
+	handlers.CleanupRoleMessages(cleanupCtx, discord, registry)
+	statsHub.Cleanup(cleanupCtx)
+
+	// 5. Закрываем соединение со шлюзом, служебный HTTP-сервер и БД
+	if err := discord.Close(); err != nil {
+		slog.Error("Error closing Discord session", logging.Err(err))
+	}
+	if server != nil {
+		if err := server.Shutdown(cleanupCtx); err != nil {
+			server.Close()
+		}
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Error closing database", logging.Err(err))
+	}
+
+	slog.Info("Bot stopped")
 }
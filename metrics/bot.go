@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// События жизненного цикла роли для RoleEvents
+const (
+	EventGranted          = "granted"           // роль выдана: с панели, по заявке или из очереди
+	EventRenewalRequested = "renewal_requested" // отправлен вопрос о продлении
+	EventRenewed          = "renewed"           // пользователь продлил роль
+	EventAutoRenewed      = "auto_renewed"      // роль продлена по активности
+	EventRestored         = "restored"          // роль восстановлена в льготный период
+	EventDropped          = "dropped"           // пользователь отказался от роли
+	EventGraceStarted     = "grace_started"     // вопрос остался без ответа, начался льготный период
+	EventExpired          = "expired"           // роль снята: вопрос или льготный период остались без ответа
+	EventDeactivated      = "deactivated"       // сверка: участник ушёл или роль сняли вручную
+	EventRevoked          = "revoked"           // роль снята через админский API
+	EventExtended         = "extended"          // роль продлена через админский API
+	EventGrantReverted    = "grant_reverted"    // Discord не выдал роль, запись удалена
+	EventRemovalReverted  = "removal_reverted"  // Discord не снял роль, запись снова активна
+)
+
+// Исходы взаимодействий для Interactions
+const (
+	OutcomeOK       = "ok"
+	OutcomeFailed   = "failed"   // внутренняя ошибка: БД, Discord
+	OutcomeRejected = "rejected" // устаревшая кнопка, неизвестное действие или сервер
+	OutcomeCooldown = "cooldown"
+)
+
+// Исходы попыток доставки вебхуков для WebhookDeliveries
+const (
+	OutcomeDelivered = "delivered"
+	OutcomeRetried   = "retried" // попытка не удалась, будет повтор
+	OutcomeAbandoned = "abandoned"
+)
+
+var (
+	RoleEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_role_events_total",
+		Help: "Role lifecycle events by guild, role and event.",
+	}, []string{"guild_id", "role_id", "event"})
+
+	Interactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_interactions_total",
+		Help: "Handled interactions by route and outcome.",
+	}, []string{"route", "outcome"})
+	InteractionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "neble_interaction_duration_seconds",
+		Help:    "Time spent handling an interaction.",
+		Buckets: DefaultBuckets,
+	}, []string{"route"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "neble_db_query_duration_seconds",
+		Help:    "Database query duration by statement type.",
+		Buckets: DefaultBuckets,
+	}, []string{"statement"})
+	DBQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_db_query_errors_total",
+		Help: "Failed database queries by statement type.",
+	}, []string{"statement"})
+
+	DiscordRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "neble_discord_request_duration_seconds",
+		Help:    "Discord REST request duration by route.",
+		Buckets: DefaultBuckets,
+	}, []string{"method", "route"})
+	DiscordRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_discord_request_errors_total",
+		Help: "Failed Discord REST requests by route and status (\"error\" when no response was received).",
+	}, []string{"method", "route", "status"})
+
+	SchedulerJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "neble_scheduler_job_duration_seconds",
+		Help:    "Scheduler job run duration.",
+		Buckets: []float64{.1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"job"})
+	SchedulerJobPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_scheduler_job_panics_total",
+		Help: "Scheduler job runs that panicked.",
+	}, []string{"job"})
+	SchedulerJobFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_scheduler_job_failures_total",
+		Help: "Scheduler job runs that returned an error.",
+	}, []string{"job"})
+
+	StatsRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_stats_refreshes_total",
+		Help: "Stats message refreshes by guild and outcome.",
+	}, []string{"guild_id", "outcome"})
+	StatsRefreshDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "neble_stats_refresh_duration_seconds",
+		Help:    "Time spent refreshing a stats message.",
+		Buckets: DefaultBuckets,
+	}, []string{"guild_id"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "neble_webhook_deliveries_total",
+		Help: "Webhook delivery attempts by webhook and outcome.",
+	}, []string{"webhook", "outcome"})
+	WebhookDeliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "neble_webhook_delivery_duration_seconds",
+		Help:    "Webhook request duration.",
+		Buckets: DefaultBuckets,
+	}, []string{"webhook"})
+)
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transport учитывает длительность и ошибки исходящих запросов к API Discord
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	started := time.Now()
+	resp, err := base.RoundTrip(req)
+
+	route := DiscordRoute(req.URL.Path)
+	DiscordRequestDuration.WithLabelValues(req.Method, route).Observe(time.Since(started).Seconds())
+	switch {
+	case err != nil:
+		DiscordRequestErrors.WithLabelValues(req.Method, route, "error").Inc()
+	case resp.StatusCode >= 400:
+		DiscordRequestErrors.WithLabelValues(req.Method, route, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// DiscordRoute приводит путь запроса к шаблону маршрута, чтобы у метрик было
+// ограниченное число меток: ID заменяются на :id, токены взаимодействий - на :token,
+// версия API отбрасывается.
+// /api/v9/guilds/123/members/456/roles/789 -> /guilds/:id/members/:id/roles/:id
+func DiscordRoute(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) >= 2 && segments[0] == "api" && strings.HasPrefix(segments[1], "v") {
+		segments = segments[2:]
+	}
+
+	for i, segment := range segments {
+		switch {
+		case isSnowflake(segment):
+			segments[i] = ":id"
+		case i >= 2 && segments[i-1] == ":id" && (segments[i-2] == "webhooks" || segments[i-2] == "interactions"):
+			segments[i] = ":token"
+		case i >= 1 && segments[i-1] == "reactions":
+			segments[i] = ":emoji"
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+func isSnowflake(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for _, r := range segment {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
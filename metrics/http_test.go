@@ -0,0 +1,31 @@
+package metrics
+
+import "testing"
+
+func TestDiscordRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v9/guilds/123/members/456/roles/789", "/guilds/:id/members/:id/roles/:id"},
+		{"/api/v10/channels/123/messages", "/channels/:id/messages"},
+		{"/api/v9/users/@me/channels", "/users/@me/channels"},
+		{"/api/v9/gateway/bot", "/gateway/bot"},
+		{"/api/v9/applications/1/guilds/2/commands", "/applications/:id/guilds/:id/commands"},
+		{"/api/v9/interactions/123/aW50ZXJhY3Rpb24/callback", "/interactions/:id/:token/callback"},
+		{"/api/v9/webhooks/123/aW50ZXJhY3Rpb24/messages/@original", "/webhooks/:id/:token/messages/@original"},
+		{"/api/v9/webhooks/123", "/webhooks/:id"},
+		{"/api/v9/channels/1/messages/2/reactions/👍/@me", "/channels/:id/messages/:id/reactions/:emoji/@me"},
+		{"/api/v9/channels/1/messages/2/reactions/name:345", "/channels/:id/messages/:id/reactions/:emoji"},
+		// Без префикса API путь остаётся как есть, кроме ID
+		{"/guilds/123", "/guilds/:id"},
+		{"/api/guilds/123", "/api/guilds/:id"},
+		{"", "/"},
+	}
+
+	for _, tt := range tests {
+		if got := DiscordRoute(tt.path); got != tt.want {
+			t.Errorf("DiscordRoute(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package metrics - метрики бота для Prometheus на github.com/prometheus/client_golang.
+// Метрики регистрируются в реестре по умолчанию и отдаются обработчиком Handler
+// вместе с метриками процесса и рантайма Go.
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"neble_2/logging"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// collectTimeout ограничивает датчики, которые при сборе обращаются к БД
+const collectTimeout = 5 * time.Second
+
+// DefaultBuckets - границы гистограмм длительностей в секундах: от 5 мс до 10 с
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Handler отдаёт все зарегистрированные метрики
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Sample - значение датчика с метками
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc - датчик, значения которого вычисляются при каждом сборе метрик
+type GaugeFunc struct {
+	name    string
+	desc    *prometheus.Desc
+	collect func(ctx context.Context) ([]Sample, error)
+}
+
+// NewGaugeFunc создаёт датчик и регистрирует его в реестре по умолчанию
+func NewGaugeFunc(name, help string, collect func(ctx context.Context) ([]Sample, error), labels ...string) *GaugeFunc {
+	g := &GaugeFunc{name: name, desc: prometheus.NewDesc(name, help, labels, nil), collect: collect}
+	prometheus.MustRegister(g)
+	return g
+}
+
+func (g *GaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *GaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	samples, err := g.collect(ctx)
+	if err != nil {
+		// Без значения датчик пропадает из выдачи, а остальные метрики отдаются как обычно
+		slog.ErrorContext(ctx, "Error collecting metric", "metric", g.name, logging.Err(err))
+		return
+	}
+	for _, s := range samples {
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, s.Value, s.Labels...)
+	}
+}
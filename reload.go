@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/customid"
+	"neble_2/guilds"
+	"neble_2/handlers"
+	"neble_2/logging"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/joho/godotenv"
+)
+
+// envFile - файл с переменными окружения рядом с ботом
+const envFile = ".env"
+
+// envLoader подгружает .env в окружение процесса. Переменные, заданные самим окружением
+// (например, в docker-compose), главнее файла - как и у godotenv.Load; при повторной
+// загрузке удалённые из файла переменные убираются из окружения.
+type envLoader struct {
+	mu       sync.Mutex
+	process  map[string]bool // переменные окружения процесса на момент запуска
+	fromFile map[string]bool // переменные, взятые из .env при прошлой загрузке
+}
+
+func newEnvLoader() *envLoader {
+	l := &envLoader{
+		process:  make(map[string]bool),
+		fromFile: make(map[string]bool),
+	}
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		l.process[key] = true
+	}
+	return l
+}
+
+// Load читает .env и применяет его переменные к окружению процесса
+func (l *envLoader) Load() error {
+	values, err := godotenv.Read(envFile)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.fromFile {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(l.fromFile, key)
+		}
+	}
+	for key, value := range values {
+		if l.process[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		l.fromFile[key] = true
+	}
+	return nil
+}
+
+// restartOnly - параметры, которые читаются один раз при запуске. При перезагрузке
+// их изменения не применяются: остаются старые значения, а в лог пишется предупреждение.
+var restartOnly = []struct {
+	key   string
+	value func(c *config.Config) any
+}{
+	{"BOT_TOKEN", func(c *config.Config) any { return c.Token }},
+	{"GUILD_ID", func(c *config.Config) any { return c.GuildID }},
+	{"CUSTOM_ID_SECRET", func(c *config.Config) any { return c.CustomIDSecret }},
+	{"DB_QUERY_TIMEOUT", func(c *config.Config) any { return c.DBQueryTimeout }},
+	{"DISCORD_RATE_LIMIT", func(c *config.Config) any { return c.DiscordRateLimit }},
+	{"DISCORD_RATE_BURST", func(c *config.Config) any { return c.DiscordRateBurst }},
+	{"SCHEDULE_EXPIRY_SCAN", func(c *config.Config) any { return c.ExpiryScanSchedule }},
+	{"SCHEDULE_TIMEOUT_RESOLUTION", func(c *config.Config) any { return c.TimeoutResolutionSchedule }},
+	{"SCHEDULE_RECONCILIATION", func(c *config.Config) any { return c.ReconciliationSchedule }},
+	{"SCHEDULE_STATS_REFRESH", func(c *config.Config) any { return c.StatsRefreshSchedule }},
+	{"SCHEDULE_CLEANUP", func(c *config.Config) any { return c.CleanupSchedule }},
+	{"SCHEDULE_WEBHOOK_DELIVERY", func(c *config.Config) any { return c.WebhookDeliverySchedule }},
+	{"SCHEDULER_JITTER", func(c *config.Config) any { return c.SchedulerJitter }},
+	{"CONFIG_WATCH_INTERVAL", func(c *config.Config) any { return c.ConfigWatchInterval }},
+	{"HTTP_ADDR", func(c *config.Config) any { return c.HTTPAddr }},
+}
+
+// keepRestartOnly переносит в новую конфигурацию параметры, которые нельзя сменить на ходу
+func keepRestartOnly(old, updated *config.Config) {
+	var ignored []string
+	for _, field := range restartOnly {
+		if !reflect.DeepEqual(field.value(old), field.value(updated)) {
+			ignored = append(ignored, field.key)
+		}
+	}
+	if len(ignored) > 0 {
+		slog.Warn("Config reload: some settings require a restart, keeping the old values", "settings", ignored)
+	}
+
+	updated.Token = old.Token
+	updated.GuildID = old.GuildID
+	updated.CustomIDSecret = old.CustomIDSecret
+	updated.DBQueryTimeout = old.DBQueryTimeout
+	updated.DiscordRateLimit = old.DiscordRateLimit
+	updated.DiscordRateBurst = old.DiscordRateBurst
+	updated.ExpiryScanSchedule = old.ExpiryScanSchedule
+	updated.TimeoutResolutionSchedule = old.TimeoutResolutionSchedule
+	updated.ReconciliationSchedule = old.ReconciliationSchedule
+	updated.StatsRefreshSchedule = old.StatsRefreshSchedule
+	updated.CleanupSchedule = old.CleanupSchedule
+	updated.WebhookDeliverySchedule = old.WebhookDeliverySchedule
+	updated.SchedulerJitter = old.SchedulerJitter
+	updated.ConfigWatchInterval = old.ConfigWatchInterval
+	updated.HTTPAddr = old.HTTPAddr
+}
+
+// configReloader перечитывает .env, каталог ролей и вебхуки по SIGHUP или при изменении файлов
+// и подменяет живую конфигурацию: обработчики, планировщик и статистика берут её
+// из реестра серверов при каждом использовании
+type configReloader struct {
+	env          *envLoader
+	session      *discordgo.Session
+	registry     *guilds.Registry
+	codec        *customid.Codec
+	refreshStats func()
+
+	mu sync.Mutex // перезагрузки не должны пересекаться
+}
+
+// Reload перечитывает конфигурацию. Конфигурация с ошибками отклоняется целиком,
+// бот продолжает работать со старой.
+func (r *configReloader) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.env.Load(); err != nil {
+		slog.Warn("Config reload: cannot read env file", "file", envFile, logging.Err(err))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("Config reload rejected, keeping the current configuration", logging.Err(err))
+		return
+	}
+	keepRestartOnly(r.registry.Base(), cfg)
+	logging.SetLevel(cfg.LogLevel)
+
+	// Панели ролей обновляются на месте, если изменились каталог или канал
+	for _, change := range r.registry.SetBase(cfg) {
+		handlers.RefreshRoleSelectionMessage(context.Background(), r.session, change.Old, change.New, r.codec)
+	}
+	r.refreshStats()
+
+	slog.Info("Configuration reloaded")
+}
+
+// Watch проверяет время изменения .env, файлов каталога ролей и вебхуков каждые interval
+// и перезагружает конфигурацию, когда какой-то из них изменился
+func (r *configReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seen := configFileTimes()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current := configFileTimes()
+			if reflect.DeepEqual(seen, current) {
+				continue
+			}
+			seen = current
+			slog.Info("Configuration files changed, reloading")
+			r.Reload()
+		}
+	}
+}
+
+// configFileTimes возвращает время изменения файлов конфигурации;
+// отсутствующий файл даёт нулевое время, так что его появление тоже заметно
+func configFileTimes() map[string]time.Time {
+	times := make(map[string]time.Time)
+	for _, path := range []string{envFile, os.Getenv("ROLE_CATALOG_FILE"), os.Getenv("WEBHOOKS_FILE")} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			times[path] = info.ModTime()
+		} else {
+			times[path] = time.Time{}
+		}
+	}
+	return times
+}
@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleIntervals(t *testing.T) {
+	tests := []struct {
+		spec string
+		want time.Duration
+	}{
+		{"30s", 30 * time.Second},
+		{"15m", 15 * time.Minute},
+		{"@every 1h", time.Hour},
+		{" @every  2m ", 2 * time.Minute},
+	}
+	from := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.Next(from).Sub(from); got != tt.want {
+			t.Errorf("ParseSchedule(%q) interval = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"0s",
+		"-5m",
+		"@every",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-x * * * *",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-03-10 - воскресенье
+	from := time.Date(2024, 3, 10, 12, 34, 56, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 10, 12, 35, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 10, 12, 45, 0, 0, time.UTC)},
+		{"0 4 * * *", time.Date(2024, 3, 11, 4, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 3, 10, 13, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 9-18/3 * * *", time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Ограничены и день месяца, и день недели - достаточно любого совпадения
+		{"0 0 20 * 2", time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.Next(from); !got.Equal(tt.want) {
+			t.Errorf("ParseSchedule(%q).Next = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestCronNeverMatches(t *testing.T) {
+	schedule, err := ParseSchedule("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	if got := schedule.Next(from); got.Before(from.AddDate(5, 0, 0)) {
+		t.Errorf("Next = %v, want the 5-year search limit", got)
+	}
+}
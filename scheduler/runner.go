@@ -0,0 +1,195 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"sync"
+	"time"
+)
+
+// Job - именованная периодическая задача
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Jitter   time.Duration
+	// Run возвращает ошибку, если запуск не выполнил свою работу: такой запуск
+	// не считается успешным для проверок готовности
+	Run func(ctx context.Context) error
+}
+
+// Leader решает, должен ли этот экземпляр бота выполнять задачи
+type Leader interface {
+	IsLeader(ctx context.Context) bool
+	Release()
+}
+
+// Scheduler запускает задачи по их расписанию, каждую в своей горутине.
+// Запуски одной задачи никогда не пересекаются.
+type Scheduler struct {
+	jobs       []Job
+	leader     Leader
+	cancel     context.CancelFunc // прекращает новые запуски
+	cancelRuns context.CancelFunc // прерывает выполняющиеся запуски
+	wg         sync.WaitGroup
+
+	mu          sync.Mutex
+	started     time.Time
+	lastSuccess map[string]time.Time // время последнего запуска каждой задачи, завершившегося без ошибки и паники
+}
+
+// JobStatus - состояние задачи для проверок готовности
+type JobStatus struct {
+	Name        string
+	LastSuccess time.Time // нулевое, если задача ещё не выполнялась
+	// Due - к этому времени ожидается следующий успешный запуск: пропущены
+	// два запуска подряд с учётом разброса
+	Due time.Time
+}
+
+// Stale сообщает, что задача давно не завершалась успешно
+func (js JobStatus) Stale(now time.Time) bool {
+	return now.After(js.Due)
+}
+
+func New() *Scheduler {
+	return &Scheduler{lastSuccess: make(map[string]time.Time)}
+}
+
+// Add регистрирует задачу. Вызывать до Start.
+func (sc *Scheduler) Add(job Job) {
+	sc.jobs = append(sc.jobs, job)
+}
+
+// SetLeader ограничивает выполнение задач экземпляром, выбранным лидером.
+// Без лидера задачи выполняются всегда.
+func (sc *Scheduler) SetLeader(leader Leader) {
+	sc.leader = leader
+}
+
+// Start запускает все зарегистрированные задачи
+func (sc *Scheduler) Start(ctx context.Context) {
+	runCtx, cancelRuns := context.WithCancel(ctx)
+	loopCtx, cancel := context.WithCancel(runCtx)
+	sc.cancel, sc.cancelRuns = cancel, cancelRuns
+
+	sc.mu.Lock()
+	sc.started = time.Now()
+	sc.mu.Unlock()
+
+	for _, job := range sc.jobs {
+		sc.wg.Add(1)
+		go sc.loop(loopCtx, runCtx, job)
+	}
+}
+
+// Stop прекращает запуски задач и дожидается выполняющихся. Если ctx истёк
+// раньше, выполняющиеся задачи отменяются, и Stop ждёт, пока они прервутся.
+func (sc *Scheduler) Stop(ctx context.Context) {
+	if sc.cancel == nil {
+		return
+	}
+	sc.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		sc.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("Scheduler jobs did not finish in time, cancelling them")
+		sc.cancelRuns()
+		<-stopped
+	}
+	sc.cancelRuns()
+
+	if sc.leader != nil {
+		sc.leader.Release()
+	}
+}
+
+// Status возвращает состояние всех задач. Пока задача не выполнялась,
+// срок отсчитывается от запуска планировщика.
+func (sc *Scheduler) Status() []JobStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	statuses := make([]JobStatus, 0, len(sc.jobs))
+	for _, job := range sc.jobs {
+		last := sc.lastSuccess[job.Name]
+		from := last
+		if from.IsZero() {
+			from = sc.started
+		}
+		statuses = append(statuses, JobStatus{
+			Name:        job.Name,
+			LastSuccess: last,
+			Due:         job.Schedule.Next(job.Schedule.Next(from)).Add(job.Jitter),
+		})
+	}
+	return statuses
+}
+
+// loop ждёт очередной запуск, пока не отменён loopCtx; сами запуски получают runCtx,
+// чтобы остановка планировщика не прерывала начатую работу
+func (sc *Scheduler) loop(loopCtx, runCtx context.Context, job Job) {
+	defer sc.wg.Done()
+
+	for {
+		now := time.Now()
+		wait := job.Schedule.Next(now).Sub(now)
+		if job.Jitter > 0 {
+			wait += rand.N(job.Jitter)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-loopCtx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		sc.run(runCtx, job)
+	}
+}
+
+// run выполняет один запуск задачи. У каждого запуска свой correlation_id:
+// по нему находятся все запросы к БД и Discord, сделанные этим запуском.
+func (sc *Scheduler) run(ctx context.Context, job Job) {
+	ctx = logging.WithCorrelationID(logging.With(ctx, logging.KeyJob, job.Name))
+
+	defer func() {
+		if r := recover(); r != nil {
+			metrics.SchedulerJobPanics.WithLabelValues(job.Name).Inc()
+			slog.ErrorContext(ctx, "Scheduler job panicked", "panic", r)
+		}
+	}()
+
+	// Реплика без лидерства исправна, просто задачи выполняет другая
+	if sc.leader != nil && !sc.leader.IsLeader(ctx) {
+		sc.markSuccess(job.Name)
+		return
+	}
+
+	started := time.Now()
+	err := job.Run(ctx)
+	metrics.SchedulerJobDuration.WithLabelValues(job.Name).Observe(time.Since(started).Seconds())
+	if err != nil {
+		metrics.SchedulerJobFailures.WithLabelValues(job.Name).Inc()
+		slog.ErrorContext(ctx, "Scheduler job failed", "duration_ms", time.Since(started).Milliseconds(), logging.Err(err))
+		return
+	}
+	sc.markSuccess(job.Name)
+	slog.InfoContext(ctx, "Scheduler job finished", "duration_ms", time.Since(started).Milliseconds())
+}
+
+func (sc *Scheduler) markSuccess(name string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.lastSuccess[name] = time.Now()
+}
@@ -1,128 +1,595 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"neble_2/config"
+	"neble_2/customid"
 	"neble_2/database"
+	"neble_2/eligibility"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"neble_2/webhooks"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-func StartScheduler(s *discordgo.Session, db *database.DB, cfg *config.Config) {
-	ticker := time.NewTicker(1 * time.Second) // Проверяем каждый час
+// leaderLockKey - ключ advisory-блокировки, которую удерживает реплика, выполняющая задачи
+const leaderLockKey int64 = 0x6e65626c65
 
-	go func() {
-		for range ticker.C {
-			checkExpiredRoles(s, db, cfg)
+// voiceRetention - сколько хранятся завершённые голосовые сессии, если окна
+// автопродления ролей не требуют больше. Статистика смотрит только на последнюю неделю.
+const voiceRetention = 30 * 24 * time.Hour
+
+// StartScheduler регистрирует задачи бота и запускает их по расписаниям из конфигурации.
+// Расписания общие и читаются один раз, а каждая задача при запуске берёт из реестра
+// актуальные конфигурации серверов.
+func StartScheduler(ctx context.Context, s *discordgo.Session, db *database.DB, registry *guilds.Registry, codec *customid.Codec, refreshStats func()) (*Scheduler, error) {
+	cfg := registry.Base()
+	dispatcher := webhooks.NewDispatcher(db, registry)
+	specs := []struct {
+		name string
+		spec string
+		run  func(ctx context.Context) error
+	}{
+		{"expiry_scan", cfg.ExpiryScanSchedule, func(ctx context.Context) error {
+			err := forEachGuild(ctx, registry, func(ctx context.Context, cfg *config.Config) error { return checkExpiredRoles(ctx, s, db, cfg, codec) })
+			return errors.Join(err, expireWaitlistReservations(ctx, db, registry.Base()))
+		}},
+		{"timeout_resolution", cfg.TimeoutResolutionSchedule, func(ctx context.Context) error {
+			return forEachGuild(ctx, registry, func(ctx context.Context, cfg *config.Config) error {
+				return resolveRenewalTimeouts(ctx, s, db, cfg, codec)
+			})
+		}},
+		{"reconciliation", cfg.ReconciliationSchedule, func(ctx context.Context) error {
+			return forEachGuild(ctx, registry, func(ctx context.Context, cfg *config.Config) error { return reconcileRoles(ctx, s, db, cfg) })
+		}},
+		{"stats_refresh", cfg.StatsRefreshSchedule, func(ctx context.Context) error {
+			refreshStats()
+			return nil
+		}},
+		{"cleanup", cfg.CleanupSchedule, func(ctx context.Context) error {
+			err := forEachGuild(ctx, registry, func(ctx context.Context, cfg *config.Config) error { return cleanupRenewalMessages(ctx, s, db, cfg) })
+			return errors.Join(err, cleanupVoiceSessions(ctx, db, registry.All()), dispatcher.Cleanup(ctx))
+		}},
+		{"webhook_delivery", cfg.WebhookDeliverySchedule, dispatcher.Run},
+	}
+
+	sc := New()
+	sc.SetLeader(db.NewLeaderElector(leaderLockKey))
+	for _, spec := range specs {
+		schedule, err := ParseSchedule(spec.spec)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", spec.name, err)
 		}
-	}()
+		sc.Add(Job{
+			Name:     spec.name,
+			Schedule: schedule,
+			Jitter:   cfg.SchedulerJitter,
+			Run:      spec.run,
+		})
+		slog.Info("Scheduled job", logging.KeyJob, spec.name, "schedule", spec.spec)
+	}
+
+	sc.Start(ctx)
+	return sc, nil
+}
+
+// forEachGuild выполняет задачу для каждого сервера по очереди, пока не отменён контекст.
+// Логи задачи получают guild_id сервера. Ошибка одного сервера не останавливает
+// остальные; возвращаются ошибки всех серверов.
+func forEachGuild(ctx context.Context, registry *guilds.Registry, run func(ctx context.Context, cfg *config.Config) error) error {
+	var errs []error
+	for _, cfg := range registry.All() {
+		if ctx.Err() != nil {
+			return errors.Join(append(errs, ctx.Err())...)
+		}
+		if err := run(logging.With(ctx, logging.KeyGuildID, cfg.GuildID), cfg); err != nil {
+			errs = append(errs, fmt.Errorf("guild %s: %w", cfg.GuildID, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func checkExpiredRoles(s *discordgo.Session, db *database.DB, cfg *config.Config) {
-	log.Printf("Checking for expired roles...")
-	expiredRoles, err := db.GetExpiredRoles()
+// roleContext добавляет к логам пользователя и запись о роли, которую обрабатывает задача
+func roleContext(ctx context.Context, role database.UserRole) context.Context {
+	return logging.With(ctx, logging.KeyUserID, role.UserID, logging.KeyAssignmentID, role.ID)
+}
+
+func checkExpiredRoles(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, codec *customid.Codec) error {
+	slog.DebugContext(ctx, "Checking for expired roles")
+	expiredRoles, err := db.ClaimExpiredRoles(ctx, cfg.GuildID)
 	if err != nil {
-		log.Printf("Error getting expired roles: %v", err)
-		return
+		return fmt.Errorf("claim expired roles: %w", err)
 	}
 
-	log.Printf("Found %d expired roles to process", len(expiredRoles))
+	slog.DebugContext(ctx, "Processing expired roles", "count", len(expiredRoles))
 
 	for _, role := range expiredRoles {
-		// Отправляем сообщение с вопросом о продлении
-		sendRenewalMessage(s, db, cfg, role)
+		ctx := roleContext(ctx, role)
+		// Активных участников продлеваем без вопроса, если это разрешено для роли
+		if autoRenew(ctx, s, db, cfg, role) {
+			continue
+		}
 
-		// Запускаем таймер для автоматического снятия роли
-		go startRenewalTimer(s, db, cfg, role)
+		// Отправляем сообщение с вопросом о продлении.
+		// Роль снимет задача timeout_resolution, если пользователь не ответит
+		sendRenewalMessage(ctx, s, db, cfg, codec, role)
 	}
+	return nil
 }
 
-func sendRenewalMessage(s *discordgo.Session, db *database.DB, cfg *config.Config, role database.UserRole) {
-	components := []discordgo.MessageComponent{
+// expireWaitlistReservations освобождает места очереди ожидания сразу на всех серверах:
+// резерв привязан к ID роли, а он уникален в Discord
+func expireWaitlistReservations(ctx context.Context, db *database.DB, cfg *config.Config) error {
+	// Места, придержанные для очереди и не занятые вовремя, передаются следующим
+	expired, err := db.ExpireWaitlistReservations(ctx, cfg.WaitlistReservation)
+	if err != nil {
+		return fmt.Errorf("expire waitlist reservations: %w", err)
+	}
+	if expired > 0 {
+		slog.InfoContext(ctx, "Expired waitlist reservations", "count", expired)
+	}
+
+	// Очереди, раздачу которых прервала ошибка, продолжаются
+	if err := db.ResumeWaitlists(ctx); err != nil {
+		return fmt.Errorf("resume waitlists: %w", err)
+	}
+	return nil
+}
+
+// autoRenew продлевает роль, если пользователь проявлял активность в пределах
+// AutoRenewWindow роли и по-прежнему проходит условия роли. Возвращает false,
+// когда нужно спросить пользователя.
+func autoRenew(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, role database.UserRole) bool {
+	definition, ok := cfg.RoleByID(role.RoleID)
+	if !ok || definition.AutoRenewWindow.Duration <= 0 {
+		return false
+	}
+
+	lastActive, err := db.GetLastActivity(ctx, cfg.GuildID, role.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting last activity", logging.Err(err))
+		return false
+	}
+	if !lastActive.Valid || time.Since(lastActive.Time) > definition.AutoRenewWindow.Duration {
+		return false
+	}
+
+	if minVoice := definition.AutoRenewMinVoice.Duration; minVoice > 0 {
+		voice, err := db.GetUserVoiceTotal(ctx, cfg.GuildID, role.UserID, time.Now().Add(-definition.AutoRenewWindow.Duration))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error getting voice time", logging.Err(err))
+			return false
+		}
+		if voice < minVoice {
+			return false
+		}
+	}
+
+	// Условия роли, включая лимит продлений подряд, автопродление не обходит:
+	// не прошедший их получит обычный вопрос о продлении, а ответ на него проверит условия
+	member, err := s.GuildMember(cfg.GuildID, role.UserID, discordgo.WithContext(ctx))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting member for auto-renewal", logging.Err(err))
+		return false
+	}
+	if err := eligibility.Check(member, definition, role.RenewalCount, time.Now()); err != nil {
+		slog.InfoContext(ctx, "Auto-renewal skipped: member is not eligible", logging.Err(err))
+		return false
+	}
+
+	role.ExpiresAt = time.Now().Add(cfg.RoleDuration)
+	err = db.WithTx(ctx, func(tx *database.DB) error {
+		if err := tx.ExtendRole(ctx, role.ID, role.RenewalStatus, role.ExpiresAt); err != nil {
+			return err
+		}
+		return webhooks.Publish(ctx, tx, cfg, metrics.EventAutoRenewed, &role)
+	})
+	if errors.Is(err, database.ErrNotFound) {
+		// Запись сняли, пока шли проверки - спрашивать уже не о чем
+		slog.InfoContext(ctx, "Auto-renewal skipped: role is no longer active")
+		return true
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error auto-renewing role", logging.Err(err))
+		return false
+	}
+
+	slog.InfoContext(ctx, "Role auto-renewed", "role", role.RoleName, "last_active", lastActive.Time)
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventAutoRenewed).Inc()
+	return true
+}
+
+func sendRenewalMessage(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, codec *customid.Codec, role database.UserRole) {
+	var channelID string
+	var msg *discordgo.Message
+	components, err := renewalComponents(codec, role.ID)
+	if err == nil {
+		channelID, msg, err = deliverRenewalMessage(ctx, s, db, cfg, role,
+			fmt.Sprintf("Ты всё ещё **%s**?", role.RoleName),
+			fmt.Sprintf("<@%s>, ты всё ещё **%s**?", role.UserID, role.RoleName),
+			components)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sending renewal message", logging.Err(err))
+		// Возвращаем запись в очередь, иначе роль снимут без вопроса
+		if err := db.ReleaseRenewalClaim(ctx, role.ID); err != nil {
+			slog.ErrorContext(ctx, "Error releasing renewal claim", logging.Err(err))
+		}
+		return
+	}
+
+	err = db.SetRenewalMessage(ctx, role.ID, channelID, msg.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error saving renewal message ID", logging.Err(err))
+	}
+
+	slog.InfoContext(ctx, "Renewal message sent", "message_id", msg.ID)
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventRenewalRequested).Inc()
+}
+
+// renewalComponents строит кнопки ответа на вопрос о продлении
+func renewalComponents(codec *customid.Codec, roleID int) ([]discordgo.MessageComponent, error) {
+	yesID, err := codec.Encode(customid.ActionRenewYes, strconv.Itoa(roleID))
+	if err != nil {
+		return nil, err
+	}
+	noID, err := codec.Encode(customid.ActionRenewNo, strconv.Itoa(roleID))
+	if err != nil {
+		return nil, err
+	}
+
+	return []discordgo.MessageComponent{
 		discordgo.ActionsRow{
 			Components: []discordgo.MessageComponent{
 				discordgo.Button{
 					Label:    "Да, продлить",
 					Style:    discordgo.SuccessButton,
-					CustomID: fmt.Sprintf("renew_yes_%d", role.ID),
+					CustomID: yesID,
 				},
 				discordgo.Button{
 					Label:    "Нет, убрать",
 					Style:    discordgo.DangerButton,
-					CustomID: fmt.Sprintf("renew_no_%d", role.ID),
+					CustomID: noID,
 				},
 			},
 		},
-	}
+	}, nil
+}
 
-	message := fmt.Sprintf("<@%s>, ты всё ещё **%s**?", role.UserID, role.RoleName)
+// deliverRenewalMessage отправляет сообщение о продлении в ЛС, если пользователь (или сервер)
+// это предпочитает, и откатывается на канал уведомлений, если ЛС закрыты.
+// channelContent - вариант текста для канала, с упоминанием пользователя.
+func deliverRenewalMessage(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, role database.UserRole, dmContent, channelContent string, components []discordgo.MessageComponent) (string, *discordgo.Message, error) {
+	if wantsRenewalDM(ctx, db, cfg, role.UserID) {
+		dm, err := s.UserChannelCreate(role.UserID, discordgo.WithContext(ctx))
+		if err == nil {
+			var msg *discordgo.Message
+			msg, err = s.ChannelMessageSendComplex(dm.ID, &discordgo.MessageSend{
+				Content:    dmContent,
+				Components: components,
+			}, discordgo.WithContext(ctx))
+			if err == nil {
+				return dm.ID, msg, nil
+			}
+		}
+		slog.WarnContext(ctx, "Could not DM user, falling back to notification channel", logging.Err(err))
+	}
 
 	msg, err := s.ChannelMessageSendComplex(cfg.NotificationChannelID, &discordgo.MessageSend{
-		Content:    message,
+		Content:    channelContent,
 		Components: components,
-	})
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	return cfg.NotificationChannelID, msg, nil
+}
 
+func wantsRenewalDM(ctx context.Context, db *database.DB, cfg *config.Config, userID string) bool {
+	pref, err := db.GetRenewalDMPreference(ctx, userID)
 	if err != nil {
-		log.Printf("Error sending renewal message: %v", err)
+		slog.ErrorContext(ctx, "Error getting DM preference", logging.UserID(userID), logging.Err(err))
+		return cfg.RenewalDMDefault
+	}
+	if pref == nil {
+		return cfg.RenewalDMDefault
+	}
+	return *pref
+}
+
+func DeleteRenewalMessage(ctx context.Context, s *discordgo.Session, cfg *config.Config, roleID int, db *database.DB) {
+	channelID, messageID, err := db.GetRenewalMessage(ctx, roleID)
+	if err != nil || messageID == "" {
+		slog.DebugContext(ctx, "No renewal message to delete", logging.AssignmentID(roleID), logging.Err(err))
 		return
 	}
 
-	err = db.SetRenewalMessageID(role.ID, msg.ID)
+	// Старые записи не хранят канал - это всегда канал уведомлений
+	if channelID == "" {
+		channelID = cfg.NotificationChannelID
+	}
+
+	err = s.ChannelMessageDelete(channelID, messageID, discordgo.WithContext(ctx))
 	if err != nil {
-		log.Printf("Error saving message ID: %v", err)
+		if !IsDiscordError(err, discordgo.ErrCodeUnknownMessage) {
+			slog.ErrorContext(ctx, "Error deleting renewal message", logging.AssignmentID(roleID), logging.Err(err))
+			return
+		}
+		// Сообщение уже удалено - достаточно забыть его ID
+	} else {
+		slog.InfoContext(ctx, "Renewal message deleted", logging.AssignmentID(roleID), "message_id", messageID)
 	}
 
-	// Обновляем статус в БД на "waiting_response"
-	err = db.UpdateRenewalStatus(role.ID, "waiting_response")
+	if err := db.ClearRenewalMessage(ctx, roleID); err != nil {
+		slog.ErrorContext(ctx, "Error clearing renewal message", logging.AssignmentID(roleID), logging.Err(err))
+	}
+}
+
+// resolveRenewalTimeouts снимает роли, на вопрос о продлении которых не ответили за RenewalDuration.
+// С льготным периодом роль сначала переходит в состояние "grace" и снимается только после него.
+func resolveRenewalTimeouts(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, codec *customid.Codec) error {
+	if cfg.GracePeriod > 0 {
+		if err := startGracePeriods(ctx, s, db, cfg, codec); err != nil {
+			return err
+		}
+	} else {
+		// Записи деактивируются в БД в момент захвата, здесь остаётся снять роль в Discord
+		var roles []database.UserRole
+		err := db.WithTx(ctx, func(tx *database.DB) error {
+			var err error
+			roles, err = tx.ClaimTimedOutRenewals(ctx, cfg.GuildID, cfg.RenewalDuration)
+			if err != nil {
+				return fmt.Errorf("claim timed out renewals: %w", err)
+			}
+			return publishAll(ctx, tx, cfg, metrics.EventExpired, roles)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, role := range roles {
+			ctx := roleContext(ctx, role)
+			DeleteRenewalMessage(ctx, s, cfg, role.ID, db)
+			// Пользователь не ответил - снимаем роль
+			err = s.GuildMemberRoleRemove(cfg.GuildID, role.UserID, role.RoleID, discordgo.WithContext(ctx))
+			if err != nil {
+				slog.ErrorContext(ctx, "Error removing role", logging.Err(err))
+			}
+
+			slog.InfoContext(ctx, "Role removed after unanswered renewal", "role", role.RoleName)
+			metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventExpired).Inc()
+		}
+	}
+
+	// Льготные периоды завершаются и тогда, когда их отключили в конфигурации
+	return expireGracePeriods(ctx, s, db, cfg)
+}
+
+// startGracePeriods переводит неотвеченные продления в льготный период: роль меняется
+// на метку неактивности, а пользователю приходит кнопка восстановления. Сообщения,
+// которые не удалось отправить в прошлый раз, отправляются заново.
+func startGracePeriods(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, codec *customid.Codec) error {
+	unnotified, err := db.ListUnnotifiedGraceRoles(ctx, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("list unnotified grace periods: %w", err)
+	}
+	for _, role := range unnotified {
+		ctx := roleContext(ctx, role)
+		if err := sendGraceMessage(ctx, s, db, cfg, codec, role); err != nil {
+			slog.ErrorContext(ctx, "Error resending grace period message", logging.Err(err))
+		}
+	}
+
+	roles, err := db.ClaimGraceRenewals(ctx, cfg.GuildID, cfg.RenewalDuration, cfg.GracePeriod)
 	if err != nil {
-		log.Printf("Error updating renewal status: %v", err)
+		return fmt.Errorf("claim timed out renewals: %w", err)
 	}
 
-	log.Printf("Successfully sent renewal message with ID: %s", msg.ID)
+	for _, role := range roles {
+		ctx := roleContext(ctx, role)
+		DeleteRenewalMessage(ctx, s, cfg, role.ID, db)
+
+		if cfg.InactiveRoleID != "" {
+			if err := s.GuildMemberRoleAdd(cfg.GuildID, role.UserID, cfg.InactiveRoleID, discordgo.WithContext(ctx)); err != nil {
+				slog.ErrorContext(ctx, "Error adding inactive role", logging.Err(err))
+			}
+			if err := s.GuildMemberRoleRemove(cfg.GuildID, role.UserID, role.RoleID, discordgo.WithContext(ctx)); err != nil {
+				slog.ErrorContext(ctx, "Error removing role", logging.Err(err))
+			}
+		}
+
+		// Запись без сообщения остаётся в выборке ListUnnotifiedGraceRoles до следующего запуска
+		if err := sendGraceMessage(ctx, s, db, cfg, codec, role); err != nil {
+			slog.ErrorContext(ctx, "Error sending grace period message", logging.Err(err))
+		}
+
+		slog.InfoContext(ctx, "Role moved to grace period", "role", role.RoleName, "grace_until", role.GraceUntil.Time)
+		metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventGraceStarted).Inc()
+	}
+	return nil
 }
 
-func DeleteRenewalMessage(s *discordgo.Session, cfg *config.Config, roleID int, db *database.DB) {
-	messageID, err := db.GetRenewalMessageID(roleID)
-	if err != nil || messageID == "" {
-		log.Printf("No message ID found for role %d: %v", roleID, err)
-		return
+// sendGraceMessage отправляет сообщение с кнопкой восстановления роли и запоминает его
+func sendGraceMessage(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config, codec *customid.Codec, role database.UserRole) error {
+	restoreID, err := codec.Encode(customid.ActionRestoreRole, strconv.Itoa(role.ID))
+	if err != nil {
+		return err
+	}
+	components := []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Восстановить роль",
+					Style:    discordgo.SuccessButton,
+					CustomID: restoreID,
+				},
+			},
+		},
 	}
 
-	err = s.ChannelMessageDelete(cfg.NotificationChannelID, messageID)
+	deadline := role.GraceUntil.Time.Format("02.01.2006 15:04")
+	channelID, msg, err := deliverRenewalMessage(ctx, s, db, cfg, role,
+		fmt.Sprintf("Роль **%s** приостановлена: на вопрос о продлении не было ответа. До %s её можно восстановить одной кнопкой.", role.RoleName, deadline),
+		fmt.Sprintf("<@%s>, роль **%s** приостановлена: на вопрос о продлении не было ответа. До %s её можно восстановить одной кнопкой.", role.UserID, role.RoleName, deadline),
+		components)
 	if err != nil {
-		log.Printf("Error deleting renewal message: %v", err)
-	} else {
-		log.Printf("Successfully deleted renewal message %s", messageID)
+		return err
+	}
+	if err := db.SetRenewalMessage(ctx, role.ID, channelID, msg.ID); err != nil {
+		return fmt.Errorf("save renewal message ID: %w", err)
+	}
+	return nil
+}
+
+// expireGracePeriods окончательно снимает роли, которые не восстановили за льготный период
+func expireGracePeriods(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config) error {
+	var roles []database.UserRole
+	err := db.WithTx(ctx, func(tx *database.DB) error {
+		var err error
+		roles, err = tx.ClaimExpiredGrace(ctx, cfg.GuildID)
+		if err != nil {
+			return fmt.Errorf("claim expired grace periods: %w", err)
+		}
+		return publishAll(ctx, tx, cfg, metrics.EventExpired, roles)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		ctx := roleContext(ctx, role)
+		DeleteRenewalMessage(ctx, s, cfg, role.ID, db)
+
+		// Без метки роль оставалась у пользователя весь льготный период
+		if err := s.GuildMemberRoleRemove(cfg.GuildID, role.UserID, role.RoleID, discordgo.WithContext(ctx)); err != nil {
+			slog.ErrorContext(ctx, "Error removing role", logging.Err(err))
+		}
+		if cfg.InactiveRoleID != "" {
+			if err := s.GuildMemberRoleRemove(cfg.GuildID, role.UserID, cfg.InactiveRoleID, discordgo.WithContext(ctx)); err != nil {
+				slog.ErrorContext(ctx, "Error removing inactive role", logging.Err(err))
+			}
+		}
+
+		slog.InfoContext(ctx, "Grace period expired, role removed", "role", role.RoleName)
+		metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventExpired).Inc()
 	}
+	return nil
 }
 
-func startRenewalTimer(s *discordgo.Session, db *database.DB, cfg *config.Config, role database.UserRole) {
-	time.Sleep(cfg.RenewalDuration)
+// publishAll ставит в очередь вебхуков событие lifecycle для каждой из записей roles,
+// захваченных в транзакции tx
+func publishAll(ctx context.Context, tx *database.DB, cfg *config.Config, lifecycle string, roles []database.UserRole) error {
+	for i := range roles {
+		if err := webhooks.Publish(ctx, tx, cfg, lifecycle, &roles[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Проверяем, ответил ли пользователь
-	currentRole, err := db.GetRoleByID(role.ID)
+// deactivateRole деактивирует запись, расходящуюся с Discord, вместе с событием для вебхуков
+func deactivateRole(ctx context.Context, db *database.DB, cfg *config.Config, role database.UserRole) {
+	err := db.WithTx(ctx, func(tx *database.DB) error {
+		if err := tx.DeactivateRole(ctx, role.ID); err != nil {
+			return err
+		}
+		return webhooks.Publish(ctx, tx, cfg, metrics.EventDeactivated, &role)
+	})
 	if err != nil {
-		log.Printf("Error getting role %d: %v", role.ID, err)
+		slog.ErrorContext(ctx, "Error deactivating role", logging.Err(err))
 		return
 	}
+	metrics.RoleEvents.WithLabelValues(cfg.GuildID, role.RoleID, metrics.EventDeactivated).Inc()
+}
 
-	// Если статус все еще "waiting_response", значит пользователь не ответил
-	if currentRole.RenewalStatus == "waiting_response" {
+// reconcileRoles сверяет активные записи с Discord: если участник покинул сервер
+// или роль сняли вручную, запись деактивируется
+func reconcileRoles(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config) error {
+	roles, err := db.GetActiveRoles(ctx, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("get active roles: %w", err)
+	}
 
-		DeleteRenewalMessage(s, cfg, role.ID, db)
-		// Пользователь не ответил - снимаем роль
-		err = s.GuildMemberRoleRemove(cfg.GuildID, role.UserID, role.RoleID)
-		if err != nil {
-			log.Printf("Error removing role from user %s: %v", role.UserID, err)
+	for _, role := range roles {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		ctx := roleContext(ctx, role)
 
-		err = db.DeactivateRole(role.ID)
+		member, err := s.GuildMember(cfg.GuildID, role.UserID, discordgo.WithContext(ctx))
 		if err != nil {
-			log.Printf("Error deactivating role %d in DB: %v", role.ID, err)
+			if IsDiscordError(err, discordgo.ErrCodeUnknownMember) {
+				slog.InfoContext(ctx, "User left the guild, deactivating role", "role", role.RoleName)
+				deactivateRole(ctx, db, cfg, role)
+				continue
+			}
+			slog.ErrorContext(ctx, "Error getting member", logging.Err(err))
+			continue
+		}
+
+		// В льготном периоде роль снята намеренно и заменена меткой
+		if role.RenewalStatus == "grace" && cfg.InactiveRoleID != "" {
+			continue
+		}
+
+		if !slices.Contains(member.Roles, role.RoleID) {
+			slog.InfoContext(ctx, "Role was removed outside the bot, deactivating", "role", role.RoleName)
+			deactivateRole(ctx, db, cfg, role)
+		}
+	}
+	return nil
+}
+
+// cleanupRenewalMessages удаляет сообщения о продлении, оставшиеся после сбоев и перезапусков
+func cleanupRenewalMessages(ctx context.Context, s *discordgo.Session, db *database.DB, cfg *config.Config) error {
+	roles, err := db.GetStaleRenewalMessages(ctx, cfg.GuildID)
+	if err != nil {
+		return fmt.Errorf("get stale renewal messages: %w", err)
+	}
+
+	for _, role := range roles {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
+		DeleteRenewalMessage(roleContext(ctx, role), s, cfg, role.ID, db)
+	}
+	return nil
+}
+
+// cleanupVoiceSessions удаляет голосовые сессии старше срока хранения
+func cleanupVoiceSessions(ctx context.Context, db *database.DB, configs []*config.Config) error {
+	deleted, err := db.DeleteVoiceSessionsBefore(ctx, time.Now().Add(-voiceRetentionFor(configs)))
+	if err != nil {
+		return fmt.Errorf("clean up voice sessions: %w", err)
+	}
+	if deleted > 0 {
+		slog.InfoContext(ctx, "Voice sessions cleaned up", "count", deleted)
+	}
+	return nil
+}
 
-		log.Printf("Role %s automatically removed from user %s", role.RoleName, role.UserName)
+// voiceRetentionFor возвращает срок хранения голосовых сессий: не меньше voiceRetention
+// и не меньше самого длинного окна автопродления на всех серверах
+func voiceRetentionFor(configs []*config.Config) time.Duration {
+	retention := voiceRetention
+	for _, cfg := range configs {
+		for _, role := range cfg.Roles {
+			retention = max(retention, role.AutoRenewWindow.Duration)
+		}
 	}
+	return retention
+}
+
+// IsDiscordError проверяет, что REST API Discord вернул ошибку с указанным кодом
+func IsDiscordError(err error, code int) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Message != nil && restErr.Message.Code == code
 }
@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"neble_2/config"
+	"testing"
+	"time"
+)
+
+func TestVoiceRetentionFor(t *testing.T) {
+	day := 24 * time.Hour
+	guild := func(windows ...time.Duration) *config.Config {
+		cfg := &config.Config{}
+		for _, w := range windows {
+			cfg.Roles = append(cfg.Roles, config.RoleDefinition{AutoRenewWindow: config.Duration{Duration: w}})
+		}
+		return cfg
+	}
+
+	tests := []struct {
+		name    string
+		configs []*config.Config
+		want    time.Duration
+	}{
+		{"no guilds", nil, voiceRetention},
+		{"no auto-renewal", []*config.Config{guild(0, 0)}, voiceRetention},
+		{"short windows", []*config.Config{guild(7 * day), guild(14 * day)}, voiceRetention},
+		{"long window", []*config.Config{guild(7 * day), guild(0, 60*day)}, 60 * day},
+	}
+
+	for _, tt := range tests {
+		if got := voiceRetentionFor(tt.configs); got != tt.want {
+			t.Errorf("%s: voiceRetentionFor = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
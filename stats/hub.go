@@ -0,0 +1,93 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"neble_2/database"
+	"neble_2/guilds"
+	"neble_2/lifecycle"
+	"neble_2/logging"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Hub ведёт сообщения статистики всех серверов: у каждого сервера с каналом
+// статистики свой StatsManager
+type Hub struct {
+	session *discordgo.Session
+	life    *lifecycle.Manager
+
+	mu       sync.Mutex
+	db       *database.DB
+	guilds   *guilds.Registry
+	managers map[string]*StatsManager
+}
+
+func NewHub(s *discordgo.Session, life *lifecycle.Manager) *Hub {
+	return &Hub{session: s, life: life, managers: make(map[string]*StatsManager)}
+}
+
+// SetDB передаёт базу: Hub создаётся раньше неё, потому что база вызывает NotifyUpdate
+func (h *Hub) SetDB(db *database.DB) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.db = db
+	for _, sm := range h.managers {
+		sm.SetDB(db)
+	}
+}
+
+func (h *Hub) SetGuilds(registry *guilds.Registry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.guilds = registry
+}
+
+// NotifyUpdate обновляет статистику всех серверов: база не сообщает,
+// на каком сервере изменились роли
+func (h *Hub) NotifyUpdate() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.db == nil || h.guilds == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+	defer cancel()
+
+	for _, cfg := range h.guilds.All() {
+		sm, ok := h.managers[cfg.GuildID]
+		if cfg.StatsChannelID == "" {
+			// Канал статистики убрали в настройках сервера
+			if ok {
+				sm.CleanupStatsMessage(ctx)
+				delete(h.managers, cfg.GuildID)
+			}
+			continue
+		}
+
+		if ok && sm.channelID != cfg.StatsChannelID {
+			// Канал сменили в настройках сервера - старое сообщение убираем
+			sm.CleanupStatsMessage(ctx)
+			ok = false
+		}
+		if !ok {
+			sm = NewStatsManager(h.session, h.life, h.db, cfg.GuildID, cfg.StatsChannelID)
+			h.managers[cfg.GuildID] = sm
+			slog.Info("Stats channel set", logging.GuildID(cfg.GuildID), "channel_id", cfg.StatsChannelID)
+		}
+		sm.NotifyUpdate()
+	}
+}
+
+// Cleanup удаляет сообщения статистики всех серверов при остановке бота
+func (h *Hub) Cleanup(ctx context.Context) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, sm := range h.managers {
+		sm.CleanupStatsMessage(ctx)
+	}
+}
@@ -1,9 +1,13 @@
 package stats
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"neble_2/database"
+	"neble_2/lifecycle"
+	"neble_2/logging"
+	"neble_2/metrics"
 	"strings"
 	"sync"
 	"time"
@@ -11,8 +15,24 @@ import (
 	"github.com/bwmarrin/discordgo"
 )
 
+// updateTimeout ограничивает одно обновление статистики вместе с запросами ников
+const updateTimeout = time.Minute
+
+// Время в голосе показывается за последние voiceWindow, не больше maxVoiceUsers участников
+const (
+	voiceWindow   = 7 * 24 * time.Hour
+	maxVoiceUsers = 5
+)
+
+// Причины отказа от ролей показываются за последние dropReasonWindow, не больше maxDropReasons строк
+const (
+	dropReasonWindow = 30 * 24 * time.Hour
+	maxDropReasons   = 10
+)
+
 type StatsManager struct {
 	session    *discordgo.Session
+	life       *lifecycle.Manager
 	db         *database.DB
 	guildID    string
 	channelID  string
@@ -21,9 +41,10 @@ type StatsManager struct {
 	lastUpdate time.Time
 }
 
-func NewStatsManager(s *discordgo.Session, db *database.DB, guildID, channelID string) *StatsManager {
+func NewStatsManager(s *discordgo.Session, life *lifecycle.Manager, db *database.DB, guildID, channelID string) *StatsManager {
 	return &StatsManager{
 		session:   s,
+		life:      life,
 		db:        db,
 		guildID:   guildID, // ДОБАВЛЯЕМ
 		channelID: channelID,
@@ -42,25 +63,53 @@ func (sm *StatsManager) NotifyUpdate() {
 
 	sm.lastUpdate = time.Now()
 
-	// Запускаем обновление в горутине чтобы не блокировать основной поток
-	go sm.updateStats()
+	// Запускаем обновление в горутине чтобы не блокировать основной поток.
+	// Во время остановки бота новые обновления не начинаются, начатые дорабатывают.
+	sm.life.Go(sm.updateStats)
 }
 
-func (sm *StatsManager) updateStats() {
-	activeRoles, err := sm.getActiveRoles()
+func (sm *StatsManager) updateStats(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+	ctx = logging.With(ctx, logging.KeyGuildID, sm.guildID)
+
+	started := time.Now()
+	outcome := metrics.OutcomeFailed
+	defer func() {
+		metrics.StatsRefreshes.WithLabelValues(sm.guildID, outcome).Inc()
+		metrics.StatsRefreshDuration.WithLabelValues(sm.guildID).Observe(time.Since(started).Seconds())
+	}()
+
+	activeRoles, err := sm.getActiveRoles(ctx)
 	if err != nil {
-		log.Printf("Error getting active roles for stats: %v", err)
+		slog.ErrorContext(ctx, "Error getting active roles for stats", logging.Err(err))
 		return
 	}
 
-	content := sm.formatStatsMessage(activeRoles)
+	// Причины - дополнение к списку ролей: без них статистика всё равно обновляется
+	reasons, err := sm.db.GetDropReasonStats(ctx, sm.guildID, time.Now().Add(-dropReasonWindow))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting drop reasons for stats", logging.Err(err))
+	}
+
+	since := time.Now().Add(-voiceWindow)
+	voiceByRole, err := sm.db.GetVoiceTotalsByRole(ctx, sm.guildID, since)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting voice totals by role for stats", logging.Err(err))
+	}
+	voiceByUser, err := sm.db.GetVoiceTotalsByUser(ctx, sm.guildID, since, maxVoiceUsers)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting voice totals by user for stats", logging.Err(err))
+	}
+
+	content := sm.formatStatsMessage(activeRoles) + formatVoiceTotals(voiceByRole, voiceByUser) + formatDropReasons(reasons)
 
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
 	if sm.messageID == "" {
 		// Первый запуск - ищем существующее сообщение или создаем новое
-		messageID, err := sm.findLastStatsMessage()
+		messageID, err := sm.findLastStatsMessage(ctx)
 		if err == nil && messageID != "" {
 			sm.messageID = messageID
 		}
@@ -68,52 +117,38 @@ func (sm *StatsManager) updateStats() {
 
 	if sm.messageID != "" {
 		// Обновляем существующее сообщение
-		_, err := sm.session.ChannelMessageEdit(sm.channelID, sm.messageID, content)
+		_, err := sm.session.ChannelMessageEdit(sm.channelID, sm.messageID, content, discordgo.WithContext(ctx))
 		if err != nil {
-			log.Printf("Error updating stats message: %v", err)
+			slog.ErrorContext(ctx, "Error updating stats message", logging.Err(err))
 			sm.messageID = "" // Сброс ID, создадим новое сообщение
 		}
 	}
 
 	if sm.messageID == "" {
 		// Создаем новое сообщение
-		msg, err := sm.session.ChannelMessageSend(sm.channelID, content)
+		msg, err := sm.session.ChannelMessageSend(sm.channelID, content, discordgo.WithContext(ctx))
 		if err != nil {
-			log.Printf("Error sending stats message: %v", err)
+			slog.ErrorContext(ctx, "Error sending stats message", logging.Err(err))
 			return
 		}
 		sm.messageID = msg.ID
 	}
+	outcome = metrics.OutcomeOK
 }
 
-func (sm *StatsManager) getActiveRoles() ([]database.UserRole, error) {
-	query := `SELECT user_id, user_name, role_name, expires_at 
-              FROM user_roles 
-              WHERE is_active = true 
-              ORDER BY role_name, user_name`
-
-	rows, err := sm.db.Query(query)
+func (sm *StatsManager) getActiveRoles(ctx context.Context) ([]database.UserRole, error) {
+	roles, err := sm.db.GetActiveRoles(ctx, sm.guildID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var roles []database.UserRole
-	for rows.Next() {
-		var role database.UserRole
-		err := rows.Scan(&role.UserID, &role.UserName, &role.RoleName, &role.ExpiresAt)
-		if err != nil {
-			return nil, err
-		}
 
+	for idx := range roles {
 		// ПОЛУЧАЕМ АКТУАЛЬНЫЙ СЕРВЕРНЫЙ НИК
-		member, err := sm.session.GuildMember(sm.guildID, role.UserID) // Замени на cfg.GuildID
+		member, err := sm.session.GuildMember(sm.guildID, roles[idx].UserID, discordgo.WithContext(ctx))
 		if err == nil && member.Nick != "" {
-			role.UserName = member.Nick // Обновляем на серверный ник
+			roles[idx].UserName = member.Nick // Обновляем на серверный ник
 		}
 		// Если серверного ника нет, остаётся глобальное имя
-
-		roles = append(roles, role)
 	}
 
 	return roles, nil
@@ -128,6 +163,10 @@ func (sm *StatsManager) formatStatsMessage(roles []database.UserRole) string {
 	sb.WriteString("**📊 Активные роли:**\n```\n")
 
 	for _, role := range roles {
+		if role.RenewalStatus == "grace" {
+			sb.WriteString(fmt.Sprintf("%s - %s (неактивен)\n", role.UserName, role.RoleName))
+			continue
+		}
 		sb.WriteString(fmt.Sprintf("%s - %s\n", role.UserName, role.RoleName))
 	}
 
@@ -135,8 +174,66 @@ func (sm *StatsManager) formatStatsMessage(roles []database.UserRole) string {
 	return sb.String()
 }
 
-func (sm *StatsManager) findLastStatsMessage() (string, error) {
-	messages, err := sm.session.ChannelMessages(sm.channelID, 10, "", "", "")
+// formatVoiceTotals - блок со временем в голосовых каналах по ролям и самым активным участникам
+func formatVoiceTotals(byRole, byUser []database.VoiceTotal) string {
+	if len(byRole) == 0 && len(byUser) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n**🎙 Голосовые каналы за 7 дней:**\n```\n")
+	for _, total := range byRole {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", total.Name, formatHours(total.Duration)))
+	}
+	if len(byUser) > 0 {
+		if len(byRole) > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("Самые активные:\n")
+		for _, total := range byUser {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", total.Name, formatHours(total.Duration)))
+		}
+	}
+	sb.WriteString("```")
+	return sb.String()
+}
+
+// formatHours выводит длительность в часах и минутах
+func formatHours(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%d ч %02d мин", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// formatDropReasons - блок с причинами отказа от ролей, пустой, если причин нет
+func formatDropReasons(reasons []database.DropReasonStat) string {
+	if len(reasons) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\n**📉 Причины отказа от ролей за 30 дней:**\n```\n")
+	for idx, reason := range reasons {
+		if idx == maxDropReasons {
+			sb.WriteString(fmt.Sprintf("... и ещё %d\n", len(reasons)-maxDropReasons))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("%s: %s - %d\n", reason.RoleName, truncate(reason.Reason, 60), reason.Count))
+	}
+	sb.WriteString("```")
+	return sb.String()
+}
+
+// truncate укорачивает свободный текст, чтобы сообщение статистики не превысило лимит Discord
+func truncate(text string, limit int) string {
+	runes := []rune(strings.ReplaceAll(text, "\n", " "))
+	if len(runes) <= limit {
+		return string(runes)
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+func (sm *StatsManager) findLastStatsMessage(ctx context.Context) (string, error) {
+	messages, err := sm.session.ChannelMessages(sm.channelID, 10, "", "", "", discordgo.WithContext(ctx))
 	if err != nil {
 		return "", err
 	}
@@ -156,16 +253,16 @@ func (sm *StatsManager) SetDB(db *database.DB) {
 	sm.db = db
 }
 
-func (sm *StatsManager) CleanupStatsMessage() {
+func (sm *StatsManager) CleanupStatsMessage(ctx context.Context) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
 	if sm.messageID != "" {
-		err := sm.session.ChannelMessageDelete(sm.channelID, sm.messageID)
+		err := sm.session.ChannelMessageDelete(sm.channelID, sm.messageID, discordgo.WithContext(ctx))
 		if err != nil {
-			log.Printf("Error deleting stats message: %v", err)
+			slog.ErrorContext(ctx, "Error deleting stats message", logging.GuildID(sm.guildID), logging.Err(err))
 		} else {
-			log.Printf("Stats message %s deleted successfully", sm.messageID)
+			slog.InfoContext(ctx, "Stats message deleted", logging.GuildID(sm.guildID), "message_id", sm.messageID)
 		}
 	}
 }
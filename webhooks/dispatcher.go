@@ -0,0 +1,194 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/database"
+	"neble_2/guilds"
+	"neble_2/logging"
+	"neble_2/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxDeliveriesPerRun ограничивает один запуск задачи доставки, чтобы большая
+	// очередь не задерживала остановку бота
+	maxDeliveriesPerRun = 500
+	// retryBase и retryMax - пауза перед повтором: удваивается с каждой неудачной
+	// попыткой, начиная с retryBase, но не больше retryMax
+	retryBase = 30 * time.Second
+	retryMax  = time.Hour
+	// retention - сколько хранятся доставленные и брошенные события
+	retention = 7 * 24 * time.Hour
+)
+
+// Dispatcher доставляет события из очереди вебхукам. Адреса и секреты берутся
+// из актуальной конфигурации при каждой попытке.
+type Dispatcher struct {
+	db       *database.DB
+	registry *guilds.Registry
+	client   *http.Client
+}
+
+func NewDispatcher(db *database.DB, registry *guilds.Registry) *Dispatcher {
+	return &Dispatcher{db: db, registry: registry, client: &http.Client{}}
+}
+
+// Run доставляет все события, чья очередь подошла. Пока очередная попытка вебхуку
+// не удалась или не наступила, его следующие события ждут. Неудачная доставка
+// ошибкой не считается - событие остаётся в очереди.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	cfg := d.registry.Base()
+	failed := make(map[string]bool) // вебхуки, попытка которым в этом запуске не удалась
+
+	for sent := 0; sent < maxDeliveriesPerRun; {
+		heads, err := d.db.GetWebhookQueueHeads(ctx)
+		if err != nil {
+			return fmt.Errorf("get webhook queue: %w", err)
+		}
+
+		progress := false
+		for _, delivery := range heads {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if failed[delivery.Webhook] || delivery.NextAttemptAt.After(time.Now()) {
+				continue
+			}
+			ok := d.deliver(ctx, cfg, delivery)
+			if !ok {
+				failed[delivery.Webhook] = true
+			}
+			progress = true
+			sent++
+		}
+		if !progress {
+			return nil
+		}
+	}
+	return nil
+}
+
+// deliver делает одну попытку доставки и записывает её результат. Возвращает false,
+// если событие осталось в очереди.
+func (d *Dispatcher) deliver(ctx context.Context, cfg *config.Config, delivery database.WebhookDelivery) bool {
+	ctx = logging.With(ctx, "webhook", delivery.Webhook, "event_id", delivery.EventID)
+
+	hook, ok := cfg.WebhookByName(delivery.Webhook)
+	if !ok {
+		// Вебхук убрали из конфигурации - его очередь больше некому доставлять
+		slog.WarnContext(ctx, "Webhook is no longer configured, dropping event")
+		metrics.WebhookDeliveries.WithLabelValues(delivery.Webhook, metrics.OutcomeAbandoned).Inc()
+		return d.abandon(ctx, delivery, "webhook removed from configuration")
+	}
+
+	err := d.send(ctx, cfg, hook, delivery)
+	if err == nil {
+		metrics.WebhookDeliveries.WithLabelValues(hook.Name, metrics.OutcomeDelivered).Inc()
+		slog.InfoContext(ctx, "Webhook event delivered", "event", delivery.Event)
+		if err := d.db.MarkWebhookDelivered(ctx, delivery.ID); err != nil {
+			// Событие останется в очереди и уйдёт повторно - получатель отбросит дубль по id
+			slog.ErrorContext(ctx, "Error updating webhook delivery", logging.Err(err))
+			return false
+		}
+		return true
+	}
+
+	attempts := delivery.Attempts + 1
+	if attempts >= cfg.WebhookMaxAttempts {
+		metrics.WebhookDeliveries.WithLabelValues(hook.Name, metrics.OutcomeAbandoned).Inc()
+		slog.ErrorContext(ctx, "Webhook delivery failed, giving up", "event", delivery.Event, "attempts", attempts, logging.Err(err))
+		return d.abandon(ctx, delivery, err.Error())
+	}
+
+	next := time.Now().Add(retryDelay(attempts))
+	metrics.WebhookDeliveries.WithLabelValues(hook.Name, metrics.OutcomeRetried).Inc()
+	slog.WarnContext(ctx, "Webhook delivery failed, will retry", "event", delivery.Event, "attempts", attempts, "next_attempt_at", next, logging.Err(err))
+	if err := d.db.RetryWebhookDelivery(ctx, delivery.ID, next, err.Error()); err != nil {
+		slog.ErrorContext(ctx, "Error updating webhook delivery", logging.Err(err))
+	}
+	return false
+}
+
+// abandon прекращает попытки доставить событие: после этого оно больше не задерживает
+// следующие события вебхука
+func (d *Dispatcher) abandon(ctx context.Context, delivery database.WebhookDelivery, reason string) bool {
+	if err := d.db.FailWebhookDelivery(ctx, delivery.ID, reason); err != nil {
+		slog.ErrorContext(ctx, "Error updating webhook delivery", logging.Err(err))
+		return false
+	}
+	return true
+}
+
+// send отправляет событие. Подпись X-Neble-Signature - HMAC-SHA256 от строки
+// "<X-Neble-Timestamp>.<тело>" с секретом вебхука; метка времени защищает от
+// повторной отправки перехваченного запроса. Успех - любой ответ 2xx.
+func (d *Dispatcher) send(ctx context.Context, cfg *config.Config, hook config.Webhook, delivery database.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "neble-bot")
+	req.Header.Set("X-Neble-Event", delivery.Event)
+	req.Header.Set("X-Neble-Delivery", delivery.EventID)
+	req.Header.Set("X-Neble-Timestamp", timestamp)
+	req.Header.Set("X-Neble-Signature", "sha256="+Sign(hook.Secret, timestamp, delivery.Payload))
+
+	started := time.Now()
+	resp, err := d.client.Do(req)
+	metrics.WebhookDeliveryDuration.WithLabelValues(hook.Name).Observe(time.Since(started).Seconds())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign возвращает подпись тела body в hex - так её проверяет получатель
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay - пауза перед попыткой после attempts неудачных
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMax)
+}
+
+// Cleanup удаляет из очереди старые доставленные и брошенные события
+func (d *Dispatcher) Cleanup(ctx context.Context) error {
+	deleted, err := d.db.DeleteFinishedWebhookDeliveries(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return fmt.Errorf("clean up webhook deliveries: %w", err)
+	}
+	if deleted > 0 {
+		slog.InfoContext(ctx, "Webhook deliveries cleaned up", "count", deleted)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{
+			name:      "payload",
+			secret:    "secret",
+			timestamp: "1700000000",
+			body:      `{"id":"1"}`,
+			want:      "086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54",
+		},
+		{
+			name:      "empty secret and body",
+			timestamp: "0",
+			want:      "b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignDependsOnInputs(t *testing.T) {
+	base := Sign("secret", "1700000000", []byte(`{"id":"1"}`))
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+	}{
+		{"other secret", "other", "1700000000", `{"id":"1"}`},
+		{"other timestamp", "secret", "1700000001", `{"id":"1"}`},
+		{"other body", "secret", "1700000000", `{"id":"2"}`},
+		// Разделитель не даёт перенести цифры из метки времени в тело
+		{"shifted separator", "secret", "170000000", `0.{"id":"1"}`},
+	}
+
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got == base {
+			t.Errorf("%s: signature did not change", tt.name)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{12, time.Hour},
+		{1000, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package webhooks сообщает внешним системам о событиях ролей: события пишутся
+// в очередь в БД и доставляются подписанными POST-запросами с повторами, так что
+// недоступность получателя их не теряет.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"neble_2/config"
+	"neble_2/database"
+	"neble_2/metrics"
+	"time"
+)
+
+// События вебхуков
+const (
+	EventGranted = "granted" // роль выдана
+	EventRenewed = "renewed" // срок роли продлён
+	EventExpired = "expired" // роль снята: на вопрос о продлении не ответили
+	EventRemoved = "removed" // роль снята: отказ пользователя, админ или сверка с Discord
+)
+
+// lifecycleEvents сопоставляет события жизненного цикла роли (metrics.Event*) событиям
+// вебхуков. Остальные события запись не меняют и получателям не отправляются.
+var lifecycleEvents = map[string]string{
+	metrics.EventGranted:     EventGranted,
+	metrics.EventRenewed:     EventRenewed,
+	metrics.EventAutoRenewed: EventRenewed,
+	metrics.EventRestored:    EventRenewed,
+	metrics.EventExtended:    EventRenewed,
+	metrics.EventExpired:     EventExpired,
+	metrics.EventDropped:     EventRemoved,
+	metrics.EventDeactivated: EventRemoved,
+	metrics.EventRevoked:     EventRemoved,
+	// Отмена изменения, которое Discord не принял, - тоже изменение записи
+	metrics.EventGrantReverted:   EventRemoved,
+	metrics.EventRemovalReverted: EventGranted,
+}
+
+// Payload - тело запроса вебхука
+type Payload struct {
+	ID           string    `json:"id"` // одинаков во всех повторах: по нему получатель отбрасывает дубли
+	Event        string    `json:"event"`
+	Detail       string    `json:"detail"` // подробное событие: "auto_renewed", "revoked", ...
+	OccurredAt   time.Time `json:"occurred_at"`
+	GuildID      string    `json:"guild_id"`
+	UserID       string    `json:"user_id"`
+	UserName     string    `json:"user_name"`
+	RoleID       string    `json:"role_id"`
+	RoleName     string    `json:"role_name"`
+	AssignmentID int       `json:"assignment_id"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// Publish ставит событие жизненного цикла роли lifecycle (metrics.Event*) в очередь
+// доставки вебхукам сервера, подписанным на него. role - запись после изменения.
+// Вызывается в транзакции db, которая меняет запись: событие попадает в очередь
+// вместе с изменением, а ошибку записи в очередь нужно вернуть из транзакции.
+func Publish(ctx context.Context, db *database.DB, cfg *config.Config, lifecycle string, role *database.UserRole) error {
+	event, ok := lifecycleEvents[lifecycle]
+	if !ok {
+		return nil
+	}
+
+	var targets []string
+	for _, hook := range cfg.Webhooks {
+		if hook.Wants(event, cfg.GuildID) {
+			targets = append(targets, hook.Name)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	eventID, err := newEventID()
+	if err != nil {
+		return fmt.Errorf("generate webhook event id: %w", err)
+	}
+	payload := Payload{
+		ID:           eventID,
+		Event:        event,
+		Detail:       lifecycle,
+		OccurredAt:   time.Now().UTC(),
+		GuildID:      cfg.GuildID,
+		UserID:       role.UserID,
+		UserName:     role.UserName,
+		RoleID:       role.RoleID,
+		RoleName:     role.RoleName,
+		AssignmentID: role.ID,
+		ExpiresAt:    role.ExpiresAt.UTC(),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encode webhook payload: %w", err)
+	}
+
+	if err := db.EnqueueWebhookDeliveries(ctx, targets, payload.ID, event, body); err != nil {
+		return fmt.Errorf("enqueue webhook event %s: %w", event, err)
+	}
+	slog.DebugContext(ctx, "Webhook event enqueued", "event", event, "event_id", payload.ID, "webhooks", targets)
+	return nil
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}